@@ -9,6 +9,76 @@ function copyToClipboard(element) {
 
 $(document).ready(function () {
 
+    var liveClicks = $('#live_clicks');
+    if (liveClicks.length && window.EventSource) {
+        var source = new EventSource(liveClicks.data('source'));
+
+        source.onopen = function() {
+            $('#live_clicks_status').text('listening');
+        };
+
+        source.onerror = function() {
+            $('#live_clicks_status').text('disconnected, retrying...');
+        };
+
+        source.addEventListener('click', function(e) {
+            var click = JSON.parse(e.data);
+            var when = new Date(click.time).toLocaleTimeString();
+            var from = click.referrer ? ' from ' + click.referrer : '';
+            var rule = click.rule ? ' [' + click.rule + ']' : '';
+
+            liveClicks.prepend(
+                $('<li class="list-group-item">').text(
+                    when + ' ' + click.short_url + ' - ' + (click.country || '??') + ', ' + click.device + from + rule));
+            liveClicks.children().slice(50).remove();
+        });
+    }
+
+    // Tag autocomplete, suggestions complete the last tag of the comma separated list.
+    var tagsInput = $('#tags');
+    if (tagsInput.length) {
+        tagsInput.on('input', function() {
+            var tags = tagsInput.val().split(',');
+            var current = tags.pop().trim();
+            var previous = tags.length ? tags.join(',') + ', ' : '';
+
+            $.getJSON('/api/tags', {q: current}, function(result) {
+                var suggestions = $('#tag_suggestions').empty();
+                $.each(result.tags, function(i, tag) {
+                    suggestions.append($('<option>').attr('value', previous + tag));
+                });
+            });
+        });
+    }
+
+    // UTM builder, previews the destination with the campaign parameters the link adds.
+    var utmPreview = $('#utm_preview');
+    if (utmPreview.length) {
+        var updateUTMPreview = function() {
+            var destination = $('#url').val().trim();
+            if (!destination) {
+                utmPreview.text('');
+                return;
+            }
+
+            try {
+                var url = new URL(/^[a-z][a-z0-9+.-]*:/i.test(destination) ? destination : 'http://' + destination);
+                $('.utm_param').each(function() {
+                    var value = $(this).val().trim();
+                    if (value && !url.searchParams.has($(this).data('param'))) {
+                        url.searchParams.set($(this).data('param'), value);
+                    }
+                });
+                utmPreview.text(url.toString());
+            } catch (e) {
+                utmPreview.text(destination);
+            }
+        };
+
+        $('#url, .utm_param').on('input', updateUTMPreview);
+        updateUTMPreview();
+    }
+
     function copyToClipboard() {
         console.log('I am here .... ');
         /* Get the text field */
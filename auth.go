@@ -98,6 +98,10 @@ func CreateToken(userid uint64, config *viper.Viper) (*TokenDetails, error) {
 
 // CreateAuth ...
 func CreateAuth(userid uint64, td *TokenDetails) error {
+	if redisClient == nil {
+		return errorRedisNotConfigured("API tokens")
+	}
+
 	at := time.Unix(td.AtExpires, 0) // converting Unix to UTC(to Time object)
 	rt := time.Unix(td.RtExpires, 0)
 	now := time.Now()
@@ -185,6 +189,10 @@ func ExtractTokenMetadata(r *http.Request, config *viper.Viper) (*AccessDetails,
 
 // FetchAuth ...
 func FetchAuth(authD *AccessDetails) (uint64, error) {
+	if redisClient == nil {
+		return 0, errorRedisNotConfigured("API tokens")
+	}
+
 	userid, err := redisClient.Get(authD.AccessUUID).Result()
 	if err != nil {
 		return 0, fmt.Errorf("error getting UUID: %w", err)
@@ -197,6 +205,10 @@ func FetchAuth(authD *AccessDetails) (uint64, error) {
 
 // DeleteAuth ...
 func DeleteAuth(uuid string) (int64, error) {
+	if redisClient == nil {
+		return 0, errorRedisNotConfigured("API tokens")
+	}
+
 	deleted, err := redisClient.Del(uuid).Result()
 	if err != nil {
 		return 0, fmt.Errorf("error deleting UUID: %w", err)
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultBlocklistRefresh is how often entries added by administrators on other replicas are
+	// picked up.
+	DefaultBlocklistRefresh = time.Minute
+
+	wildcardPrefix = "*."
+)
+
+// hostsFileNames are the local names found in hosts formatted lists, they are never blocked.
+var hostsFileNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"0.0.0.0":               true,
+}
+
+// blocklistRules is a compiled set of blocklist entries: exact domains, wildcard domains
+// ("*.example.com" blocks every subdomain of example.com) and URL prefixes.
+type blocklistRules struct {
+	domains   map[string]bool
+	wildcards map[string]bool
+	urls      []string
+}
+
+func newBlocklistRules() *blocklistRules {
+	return &blocklistRules{
+		domains:   map[string]bool{},
+		wildcards: map[string]bool{},
+	}
+}
+
+func (r *blocklistRules) size() int {
+	return len(r.domains) + len(r.wildcards) + len(r.urls)
+}
+
+// add compiles a single entry, URLs are normalized the same way destinations are.
+func (r *blocklistRules) add(entry string) error {
+	entry = strings.TrimSpace(entry)
+
+	switch {
+	case entry == "":
+		return errorInvalidBlocklistEntry(entry, "the entry is empty")
+	case strings.Contains(entry, "/"):
+		normalized, err := urlPolicy.normalize(entry)
+		if err != nil {
+			return errorInvalidBlocklistEntry(entry, err.Error())
+		}
+
+		r.urls = append(r.urls, strings.TrimSuffix(normalized, "/"))
+	case strings.HasPrefix(entry, wildcardPrefix):
+		domain, err := normalizeHost(strings.TrimPrefix(entry, wildcardPrefix))
+		if err != nil {
+			return errorInvalidBlocklistEntry(entry, err.Error())
+		}
+
+		r.wildcards[domain] = true
+	default:
+		domain, err := normalizeHost(entry)
+		if err != nil {
+			return errorInvalidBlocklistEntry(entry, err.Error())
+		}
+
+		r.domains[domain] = true
+	}
+
+	return nil
+}
+
+// match returns the entry blocking destination, if any.
+func (r *blocklistRules) match(destination string) (string, bool) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	if r.domains[host] {
+		return host, true
+	}
+
+	for parent := host; strings.Contains(parent, "."); {
+		parent = parent[strings.Index(parent, ".")+1:]
+		if r.wildcards[parent] {
+			return wildcardPrefix + parent, true
+		}
+	}
+
+	for _, prefix := range r.urls {
+		if !strings.HasPrefix(destination, prefix) {
+			continue
+		}
+
+		// "http://example.com/bad" blocks "http://example.com/bad/page" but not ".../badge".
+		if rest := destination[len(prefix):]; rest == "" || strings.ContainsAny(rest[:1], "/?#") {
+			return prefix, true
+		}
+	}
+
+	return "", false
+}
+
+// blocklistLineEntries returns the entries of a line in either a plain list (one domain, wildcard
+// domain or URL per line) or a hosts file ("0.0.0.0 example.com"). Comments start with '#'.
+func blocklistLineEntries(line string) []string {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if strings.HasPrefix(field, "#") {
+			fields = fields[:i]
+
+			break
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if net.ParseIP(fields[0]) == nil {
+		return fields[:1]
+	}
+
+	entries := []string{}
+
+	for _, name := range fields[1:] {
+		if !hostsFileNames[strings.ToLower(name)] {
+			entries = append(entries, name)
+		}
+	}
+
+	return entries
+}
+
+// readBlocklistFile adds the entries of a blocklist file to rules. Invalid entries are skipped,
+// large third party lists are rarely perfectly clean.
+func readBlocklistFile(filename string, rules *blocklistRules) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("error opening blocklist file: %w", err)
+	}
+
+	defer file.Close()
+
+	skipped := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		for _, entry := range blocklistLineEntries(scanner.Text()) {
+			if err := rules.add(entry); err != nil {
+				skipped++
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading blocklist file: %w", err)
+	}
+
+	if skipped > 0 {
+		log.Printf("%d invalid entries skipped in blocklist %q", skipped, filename)
+	}
+
+	return nil
+}
+
+// Blocklist holds the malicious destinations loaded from the blocklist files plus the entries
+// added by administrators.
+type Blocklist struct {
+	mu        sync.RWMutex
+	files     []string
+	rules     *blocklistRules
+	fileRules int
+}
+
+func newBlocklist(config *viper.Viper) *Blocklist {
+	b := &Blocklist{
+		rules: newBlocklistRules(),
+	}
+
+	for _, filename := range strings.Split(config.GetString("BLOCKLIST_FILES"), ",") {
+		if filename = strings.TrimSpace(filename); filename != "" {
+			b.files = append(b.files, filename)
+		}
+	}
+
+	return b
+}
+
+// reload rebuilds the blocklist from the files and the stored entries.
+func (b *Blocklist) reload() error {
+	rules := newBlocklistRules()
+
+	for _, filename := range b.files {
+		if err := readBlocklistFile(filename, rules); err != nil {
+			return err
+		}
+	}
+
+	fileRules := rules.size()
+
+	entries, err := (*blocklistDAO).findAll()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := rules.add(entry.Entry); err != nil {
+			log.Printf("error loading blocklist entry: %v", err)
+		}
+	}
+
+	b.mu.Lock()
+	b.rules = rules
+	b.fileRules = fileRules
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *Blocklist) add(entry string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rules.add(entry)
+}
+
+// match returns the entry blocking destination, if any.
+func (b *Blocklist) match(destination string) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.rules.match(destination)
+}
+
+// watch reloads the blocklist every interval and whenever the process receives a SIGHUP.
+func (b *Blocklist) watch(interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-hangup:
+			case <-ticker.C:
+			}
+
+			if err := b.reload(); err != nil {
+				log.Printf("error reloading blocklist: %v", err)
+			}
+		}
+	}()
+}
+
+// disableBlockedLinks disables every existing link whose destination matches entry and returns
+// how many were disabled.
+func disableBlockedLinks(entry string) (int, error) {
+	rules := newBlocklistRules()
+	if err := rules.add(entry); err != nil {
+		return 0, err
+	}
+
+	urls, err := (*urlDAO).findAll()
+	if err != nil {
+		return 0, err
+	}
+
+	disabled := 0
+
+	for _, u := range urls {
+		if _, blocked := rules.match(u.Url); !blocked {
+			continue
+		}
+
+		if err := (*urlDAO).disable(u.ShortID); err != nil {
+			return disabled, err
+		}
+
+		disabled++
+	}
+
+	return disabled, nil
+}
+
+// renderFlaggedLink shows the warning interstitial in place of the redirect to a flagged link.
+func renderFlaggedLink(c *gin.Context, shortURL, destination string) {
+	c.HTML(
+		http.StatusForbidden,
+		"flagged.html",
+		gin.H{
+			"title":       "Warning: flagged link",
+			"short_url":   shortURL,
+			"destination": destination,
+		},
+	)
+}
+
+// isAdmin tells whether the user is listed in ADMIN_USERS.
+func isAdmin(user *interface{}) bool {
+	name, ok := userName(user)
+	if !ok {
+		return false
+	}
+
+	for _, admin := range strings.Split(envConfig.GetString("ADMIN_USERS"), ",") {
+		if strings.TrimSpace(admin) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sessionAdmin returns the username of the logged in administrator, rendering the error page
+// when the user is not one.
+func sessionAdmin(c *gin.Context) (string, bool) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if !isAdmin(&userFound) {
+		c.HTML(
+			http.StatusForbidden,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": `You have to be an administrator.`,
+			},
+		)
+
+		return "", false
+	}
+
+	name, _ := userName(&userFound)
+
+	return name, true
+}
+
+func renderBlocklistPage(c *gin.Context, status int, data gin.H) {
+	entries, err := (*blocklistDAO).findAll()
+	if err != nil {
+		data["ErrorMessage"] = err.Error()
+	}
+
+	blocklist.mu.RLock()
+	data["file_entries"] = blocklist.fileRules
+	data["files"] = blocklist.files
+	blocklist.mu.RUnlock()
+
+	data["title"] = "Blocklist"
+	data["entries"] = entries
+
+	c.HTML(status, "blocklist.html", data)
+}
+
+func showBlocklistPage(c *gin.Context) {
+	if _, ok := sessionAdmin(c); !ok {
+		return
+	}
+
+	renderBlocklistPage(c, http.StatusOK, gin.H{})
+}
+
+// addBlocklistEntry stores a new entry, applies it right away and disables the existing links
+// it matches.
+func addBlocklistEntry(c *gin.Context) {
+	admin, ok := sessionAdmin(c)
+	if !ok {
+		return
+	}
+
+	entry := strings.TrimSpace(c.PostForm("entry"))
+
+	if err := newBlocklistRules().add(entry); err != nil {
+		renderBlocklistPage(c, http.StatusBadRequest, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	err := (*blocklistDAO).save(BlocklistEntry{
+		ID:        newEventID(),
+		Entry:     entry,
+		AddedBy:   admin,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		renderBlocklistPage(c, http.StatusInternalServerError, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	_ = blocklist.add(entry)
+
+	disabled, err := disableBlockedLinks(entry)
+	if err != nil {
+		renderBlocklistPage(c, http.StatusInternalServerError, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	renderBlocklistPage(c, http.StatusOK, gin.H{
+		"Message": fmt.Sprintf("%s added to the blocklist, %d existing links disabled.", entry, disabled),
+	})
+}
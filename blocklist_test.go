@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBlocklistRulesMatch(t *testing.T) {
+	useMemoryEngine(t)
+
+	rules := newBlocklistRules()
+
+	for _, entry := range []string{"Evil.com", "*.phish.net", "https://example.com/bad", "xn--bcher-kva.de"} {
+		if err := rules.add(entry); err != nil {
+			t.Fatalf("add(%q) = %v", entry, err)
+		}
+	}
+
+	tests := []struct {
+		destination string
+		entry       string
+	}{
+		{destination: "https://evil.com/", entry: "evil.com"},
+		{destination: "http://EVIL.com./login", entry: "evil.com"},
+		{destination: "https://www.evil.com/"},
+		{destination: "https://notevil.com/"},
+		{destination: "https://login.phish.net/", entry: "*.phish.net"},
+		{destination: "https://a.b.phish.net/x", entry: "*.phish.net"},
+		{destination: "https://phish.net/"},
+		{destination: "https://example.com/bad", entry: "https://example.com/bad"},
+		{destination: "https://example.com/bad/page", entry: "https://example.com/bad"},
+		{destination: "https://example.com/bad?x=1", entry: "https://example.com/bad"},
+		{destination: "https://example.com/badge"},
+		{destination: "http://example.com/bad"},
+		{destination: "https://xn--bcher-kva.de/", entry: "xn--bcher-kva.de"},
+		{destination: "://not a url"},
+	}
+
+	for _, tt := range tests {
+		entry, blocked := rules.match(tt.destination)
+		if blocked != (tt.entry != "") || entry != tt.entry {
+			t.Errorf("match(%q) = %q, %v, want %q", tt.destination, entry, blocked, tt.entry)
+		}
+	}
+}
+
+func TestBlocklistRulesAdd(t *testing.T) {
+	useMemoryEngine(t)
+
+	for _, entry := range []string{"", "*.", "not_a_domain", "javascript:alert(1)/x"} {
+		if err := newBlocklistRules().add(entry); !errors.Is(err, errInvalidBlocklist) {
+			t.Errorf("add(%q) = %v, want an invalid blocklist entry error", entry, err)
+		}
+	}
+}
+
+func TestBlocklistLineEntries(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: "evil.com", want: []string{"evil.com"}},
+		{line: "  *.phish.net  # phishing", want: []string{"*.phish.net"}},
+		{line: "# comment"},
+		{line: ""},
+		{line: "0.0.0.0 evil.com www.evil.com", want: []string{"evil.com", "www.evil.com"}},
+		{line: "127.0.0.1 localhost", want: []string{}},
+		{line: "::1 ip6-localhost ip6-loopback", want: []string{}},
+		{line: "0.0.0.0 evil.com #ads", want: []string{"evil.com"}},
+	}
+
+	for _, tt := range tests {
+		if got := blocklistLineEntries(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("blocklistLineEntries(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestReadBlocklistFile(t *testing.T) {
+	useMemoryEngine(t)
+
+	filename := filepath.Join(t.TempDir(), "hosts")
+	content := "# hosts\n127.0.0.1 localhost\n0.0.0.0 evil.com\n0.0.0.0 bad_name\n*.phish.net\n"
+
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := newBlocklistRules()
+	if err := readBlocklistFile(filename, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	if rules.size() != 2 {
+		t.Fatalf("%d rules loaded, want 2", rules.size())
+	}
+
+	if _, blocked := rules.match("https://evil.com/"); !blocked {
+		t.Error("evil.com is not blocked")
+	}
+
+	if _, blocked := rules.match("http://localhost/"); blocked {
+		t.Error("localhost is blocked")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// defaultBotSignatures are the User-Agent patterns (case insensitive regular expressions) that
+// are always considered crawler or link-preview traffic.
+var defaultBotSignatures = []string{
+	`bot\b`,
+	`crawler`,
+	`spider`,
+	`slurp`,
+	`facebookexternalhit`,
+	`facebookcatalog`,
+	`slackbot`,
+	`slack-imgproxy`,
+	`twitterbot`,
+	`linkedinbot`,
+	`discordbot`,
+	`telegrambot`,
+	`whatsapp`,
+	`skypeuripreview`,
+	`embedly`,
+	`pinterest`,
+	`vkshare`,
+	`redditbot`,
+	`applebot`,
+	`bingpreview`,
+	`google-pagerenderer`,
+	`googleimageproxy`,
+	`headlesschrome`,
+	`phantomjs`,
+	`curl/`,
+	`wget/`,
+	`python-requests`,
+	`go-http-client`,
+	`okhttp`,
+	// The default User-Agents of the JDK HTTP clients, anchored so an app embedding a Java client
+	// in a browser-like User-Agent is not matched.
+	`^java/`,
+	`^java-http-client/`,
+}
+
+// prefetchHeaders are request headers browsers and proxies set when a page is fetched
+// speculatively rather than visited.
+var prefetchHeaders = map[string][]string{
+	"Purpose":     {"prefetch", "preview"},
+	"Sec-Purpose": {"prefetch", "prerender"},
+	"X-Purpose":   {"preview"},
+	"X-Moz":       {"prefetch"},
+}
+
+// BotDetector classifies requests as bot traffic, its signature list can be replaced at runtime.
+type BotDetector struct {
+	mu         sync.RWMutex
+	signatures []*regexp.Regexp
+}
+
+func newBotDetector(signatures []string) (*BotDetector, error) {
+	detector := &BotDetector{}
+	if err := detector.update(signatures); err != nil {
+		return nil, err
+	}
+
+	return detector, nil
+}
+
+// update replaces the User-Agent signatures with the built-in ones plus the given ones.
+func (d *BotDetector) update(signatures []string) error {
+	compiled := make([]*regexp.Regexp, 0, len(defaultBotSignatures)+len(signatures))
+
+	for _, signature := range append(defaultBotSignatures, signatures...) {
+		re, err := regexp.Compile("(?i)" + signature)
+		if err != nil {
+			return errorInvalidBotSignature(signature, err)
+		}
+
+		compiled = append(compiled, re)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.signatures = compiled
+
+	return nil
+}
+
+// isBot reports whether the request looks like it was made by a crawler, a link unfurler
+// or a prefetch rather than a person clicking the link.
+func (d *BotDetector) isBot(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	for header, values := range prefetchHeaders {
+		got := strings.ToLower(r.Header.Get(header))
+		for _, value := range values {
+			if got == value {
+				return true
+			}
+		}
+	}
+
+	userAgent := r.UserAgent()
+	if strings.TrimSpace(userAgent) == "" {
+		return true
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, signature := range d.signatures {
+		if signature.MatchString(userAgent) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readBotSignatures reads one pattern per line, empty lines and lines starting with '#' are ignored.
+func readBotSignatures(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening bot signatures file: %w", err)
+	}
+
+	defer file.Close()
+
+	var signatures []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		signatures = append(signatures, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading bot signatures file: %w", err)
+	}
+
+	return signatures, nil
+}
+
+// reloadBotSignatures re-reads the configured signatures file, if any, into the detector.
+func reloadBotSignatures(detector *BotDetector, filename string) error {
+	if filename == "" {
+		return detector.update(nil)
+	}
+
+	signatures, err := readBotSignatures(filename)
+	if err != nil {
+		return err
+	}
+
+	return detector.update(signatures)
+}
+
+// watchBotSignatures reloads the signatures file every time the process receives a SIGHUP,
+// so the list can be updated without a restart.
+func watchBotSignatures(detector *BotDetector, filename string) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			if err := reloadBotSignatures(detector, filename); err != nil {
+				log.Printf("error reloading bot signatures: %v", err)
+
+				continue
+			}
+
+			log.Printf("bot signatures reloaded from %q", filename)
+		}
+	}()
+}
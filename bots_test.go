@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsBot(t *testing.T) {
+	detector, err := newBotDetector([]string{`^internal-monitor`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		userAgent string
+		headers   map[string]string
+		want      bool
+	}{
+		{userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"},
+		{userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", want: true},
+		{userAgent: "curl/8.4.0", want: true},
+		{userAgent: "Java/1.8.0_292", want: true},
+		{userAgent: "Java-http-client/17.0.2", want: true},
+		{userAgent: "Mozilla/5.0 (Linux; Android 13) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36 MyApp java/2.1"},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 Chrome/120.0 Safari/537.36 JavaFX/17"},
+		{userAgent: "internal-monitor/1.0", want: true},
+		{userAgent: "", want: true},
+		{
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+			headers:   map[string]string{"Sec-Purpose": "prefetch"},
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/u/abc", nil)
+		req.Header.Set("User-Agent", tt.userAgent)
+
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+
+		if got := detector.isBot(req); got != tt.want {
+			t.Errorf("isBot(%q, %v) = %v, want %v", tt.userAgent, tt.headers, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	bulkMaxRows = 1000
+	bulkMaxSize = 5 << 20
+
+	// maxAliasLength keeps the ID of an alias within an int.
+	maxAliasLength = 10
+
+	bulkFormatCSV  = "csv"
+	bulkFormatJSON = "json"
+)
+
+// bulkColumns are the CSV columns read when the file has no header row.
+var bulkColumns = []string{"destination", "alias", "tags", "expires_at"}
+
+var bulkColumnNames = map[string]string{
+	"destination": "destination",
+	"url":         "destination",
+	"alias":       "alias",
+	"tags":        "tags",
+	"folder":      "folder",
+	"expires_at":  "expires_at",
+	"expiry":      "expires_at",
+}
+
+// BulkRow is a link to create from a bulk upload. ExpiresAt is RFC 3339 or YYYY-MM-DD, a plain
+// day means the link works until the end of that day.
+type BulkRow struct {
+	Destination string   `json:"destination"`
+	Alias       string   `json:"alias,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Folder      string   `json:"folder,omitempty"`
+	ExpiresAt   string   `json:"expires_at,omitempty"`
+}
+
+// BulkResult is the outcome of a single BulkRow, Row is 1-based and does not count the CSV header.
+type BulkResult struct {
+	Row         int    `json:"row"`
+	Destination string `json:"destination"`
+	Alias       string `json:"alias,omitempty"`
+	ShortURL    string `json:"short_url,omitempty"`
+	LittleuLink string `json:"littleu_link,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func validateAlias(alias string) error {
+	if len(alias) > maxAliasLength {
+		return errorInvalidAlias(alias, "it cannot be longer than "+strconv.Itoa(maxAliasLength)+" characters")
+	}
+
+	for _, r := range alias {
+		if !strings.ContainsRune(string(chars), r) {
+			return errorInvalidAlias(alias, "only letters and digits are allowed")
+		}
+	}
+
+	// 'a' is the zero digit of short codes, a leading one would be dropped.
+	if idToShortURL(shortURLToID(alias, chars), chars) != alias {
+		return errorInvalidAlias(alias, "it cannot start with 'a'")
+	}
+
+	return nil
+}
+
+// bulkFormat tells whether the upload is CSV or JSON from the file name or the content type.
+func bulkFormat(filename, contentType string) string {
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		return bulkFormatJSON
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		return bulkFormatJSON
+	}
+
+	return bulkFormatCSV
+}
+
+func readBulkCSV(r io.Reader) ([]BulkRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errorInvalidBulkFile(err.Error())
+	}
+
+	if len(records) == 0 {
+		return nil, errorInvalidBulkFile("the file is empty")
+	}
+
+	columns := bulkColumns
+
+	if _, isHeader := bulkColumnNames[strings.ToLower(strings.TrimSpace(records[0][0]))]; isHeader {
+		columns = make([]string, len(records[0]))
+		for i, name := range records[0] {
+			columns[i] = bulkColumnNames[strings.ToLower(strings.TrimSpace(name))]
+		}
+
+		records = records[1:]
+	}
+
+	rows := make([]BulkRow, 0, len(records))
+
+	for _, record := range records {
+		var row BulkRow
+
+		for i, value := range record {
+			if i >= len(columns) {
+				break
+			}
+
+			value = strings.TrimSpace(value)
+
+			switch columns[i] {
+			case "destination":
+				row.Destination = value
+			case "alias":
+				row.Alias = value
+			case "tags":
+				row.Tags = splitTags(value)
+			case "folder":
+				row.Folder = value
+			case "expires_at":
+				row.ExpiresAt = value
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// readBulkRows reads the rows from the "file" form field or, for API clients, the request body.
+func readBulkRows(c *gin.Context) ([]BulkRow, string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, bulkMaxSize)
+
+	var (
+		body   io.Reader
+		format string
+	)
+
+	if fileHeader, err := c.FormFile("file"); err == nil {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, "", errorInvalidBulkFile(err.Error())
+		}
+
+		defer file.Close()
+
+		body = file
+		format = bulkFormat(fileHeader.Filename, fileHeader.Header.Get("Content-Type"))
+	} else {
+		body = c.Request.Body
+		format = bulkFormat("", c.ContentType())
+	}
+
+	var (
+		rows []BulkRow
+		err  error
+	)
+
+	if format == bulkFormatJSON {
+		if err = json.NewDecoder(body).Decode(&rows); err != nil {
+			err = errorInvalidBulkFile(err.Error())
+		}
+	} else {
+		rows, err = readBulkCSV(body)
+	}
+
+	if err != nil {
+		return nil, format, err
+	}
+
+	if len(rows) > bulkMaxRows {
+		return nil, format, errorInvalidBulkFile("no more than " + strconv.Itoa(bulkMaxRows) + " rows are allowed")
+	}
+
+	return rows, format, nil
+}
+
+// prepareBulkRow validates a row the same way shorturl validates a single link.
+func prepareBulkRow(row BulkRow, requestHost string, aliases map[string]bool) (URL, error) {
+	normalized, err := urlPolicy.normalize(row.Destination)
+	if err != nil {
+		return URL{}, err
+	}
+
+	resolved, err := resolveShortLinkChain(normalized, requestHost)
+	if err != nil {
+		return URL{}, err
+	}
+
+	if entry, blocked := blocklist.match(resolved); blocked {
+		return URL{}, errorBlockedDestination(entry)
+	}
+
+	folder, err := normalizeFolder(row.Folder)
+	if err != nil {
+		return URL{}, err
+	}
+
+	url := URL{
+		URL:    resolved,
+		Tags:   normalizeTags(row.Tags),
+		Folder: folder,
+	}
+
+	if row.Alias != "" {
+		if err := validateAlias(row.Alias); err != nil {
+			return URL{}, err
+		}
+
+		if _, err := (*urlDAO).findByID(shortURLToID(row.Alias, chars)); err == nil || aliases[row.Alias] {
+			return URL{}, errorAliasTaken(row.Alias)
+		}
+
+		aliases[row.Alias] = true
+		url.Alias = row.Alias
+	}
+
+	if row.ExpiresAt != "" {
+		expiresAt, err := parseStatsDate(row.ExpiresAt, true)
+		if err != nil {
+			return URL{}, err
+		}
+
+		if !expiresAt.After(time.Now()) {
+			return URL{}, errorExpiryInPast(row.ExpiresAt)
+		}
+
+		url.ExpiresAt = &expiresAt
+	}
+
+	return url, nil
+}
+
+func writeBulkResults(c *gin.Context, format string, results []BulkResult) {
+	filename := "littleu-bulk-results." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if format == bulkFormatJSON {
+		c.JSON(http.StatusOK, results)
+
+		return
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", csvContentType)
+
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"row", "destination", "alias", "short_url", "littleu_link", "error"})
+
+	for _, result := range results {
+		_ = writer.Write([]string{
+			strconv.Itoa(result.Row), result.Destination, result.Alias, result.ShortURL, result.LittleuLink, result.Error,
+		})
+	}
+
+	writer.Flush()
+}
+
+func showBulkPage(c *gin.Context) {
+	c.HTML(
+		http.StatusOK,
+		"bulk.html",
+		gin.H{
+			"title":    "Bulk links",
+			"max_rows": bulkMaxRows,
+		},
+	)
+}
+
+// bulkShorten creates a link for every valid row of a CSV or JSON upload. The valid rows are saved
+// together with URLDao.saveBatch, the result file has the short link or the error of every row.
+func bulkShorten(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	rows, format, err := readBulkRows(c)
+	if err != nil {
+		if wantsJSON(c) || format == bulkFormatJSON {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		c.HTML(
+			http.StatusBadRequest,
+			"bulk.html",
+			gin.H{
+				"title":        "Bulk links",
+				"max_rows":     bulkMaxRows,
+				"ErrorMessage": err.Error(),
+			},
+		)
+
+		return
+	}
+
+	results := make([]BulkResult, len(rows))
+	aliases := map[string]bool{}
+
+	var (
+		urls []URL
+		// urlRows maps every url to its row in results.
+		urlRows []int
+	)
+
+	for i, row := range rows {
+		results[i] = BulkResult{Row: i + 1, Destination: row.Destination, Alias: row.Alias}
+
+		url, err := prepareBulkRow(row, c.Request.Host, aliases)
+		if err != nil {
+			results[i].Error = err.Error()
+
+			continue
+		}
+
+		urls = append(urls, url)
+		urlRows = append(urlRows, i)
+	}
+
+	if len(urls) > 0 {
+		ids, err := (*urlDAO).saveBatch(urls, &userFound)
+
+		for j, i := range urlRows {
+			if err != nil {
+				results[i].Error = err.Error()
+
+				continue
+			}
+
+			results[i].ShortURL = idToShortURL(ids[j], chars)
+			results[i].LittleuLink = shortLinkURL(c, results[i].ShortURL)
+
+			metadataFetcher.enqueue(ids[j], urls[j].URL)
+		}
+
+		if key, ok := userKey(&userFound); ok && err == nil {
+			for j, i := range urlRows {
+				dispatchEvent(key, eventLinkCreated, LinkEventData{ShortURL: results[i].ShortURL, URL: urls[j].URL})
+			}
+		}
+	}
+
+	writeBulkResults(c, format, results)
+}
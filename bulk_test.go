@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadBulkCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want []BulkRow
+		err  bool
+	}{
+		{
+			name: "no header",
+			csv:  "https://example.com,promo,\"a,b\",2030-01-02\n",
+			want: []BulkRow{{Destination: "https://example.com", Alias: "promo", Tags: []string{"a", "b"}, ExpiresAt: "2030-01-02"}},
+		},
+		{
+			name: "header with aliases and other order",
+			csv:  "Folder, URL ,expiry\nwork,https://example.com/a,2030-01-02\n",
+			want: []BulkRow{{Destination: "https://example.com/a", Folder: "work", ExpiresAt: "2030-01-02"}},
+		},
+		{
+			name: "unknown columns are ignored",
+			csv:  "url,notes\nhttps://example.com,hello\n",
+			want: []BulkRow{{Destination: "https://example.com"}},
+		},
+		{
+			name: "short and long rows",
+			csv:  "https://example.com\nhttps://example.org,,,,extra\n",
+			want: []BulkRow{{Destination: "https://example.com"}, {Destination: "https://example.org"}},
+		},
+		{
+			name: "header only",
+			csv:  "destination,alias\n",
+			want: []BulkRow{},
+		},
+		{name: "empty", csv: "", err: true},
+		{name: "bad quoting", csv: "\"https://example.com\n", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := readBulkCSV(strings.NewReader(tt.csv))
+
+			if tt.err {
+				if !errors.Is(err, errInvalidBulkFile) {
+					t.Fatalf("readBulkCSV() error = %v, want an invalid bulk file error", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Fatalf("readBulkCSV() = %+v, want %+v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkFormat(t *testing.T) {
+	tests := []struct {
+		filename, contentType, want string
+	}{
+		{filename: "links.json", want: bulkFormatJSON},
+		{filename: "LINKS.JSON", want: bulkFormatJSON},
+		{contentType: "application/json; charset=utf-8", want: bulkFormatJSON},
+		{filename: "links.csv", contentType: "text/csv", want: bulkFormatCSV},
+		{want: bulkFormatCSV},
+	}
+
+	for _, tt := range tests {
+		if got := bulkFormat(tt.filename, tt.contentType); got != tt.want {
+			t.Errorf("bulkFormat(%q, %q) = %q, want %q", tt.filename, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		alias string
+		valid bool
+	}{
+		{alias: "promo", valid: true},
+		{alias: "Promo2030", valid: true},
+		{alias: "zzzzzzzzzz", valid: true},
+		{alias: "zzzzzzzzzzz"},
+		{alias: "apromo"},
+		{alias: "pro-mo"},
+		{alias: "promo!"},
+	}
+
+	for _, tt := range tests {
+		err := validateAlias(tt.alias)
+
+		if tt.valid && err != nil {
+			t.Errorf("validateAlias(%q) = %v, want no error", tt.alias, err)
+		}
+
+		if !tt.valid && !errors.Is(err, errInvalidAlias) {
+			t.Errorf("validateAlias(%q) = %v, want an invalid alias error", tt.alias, err)
+		}
+	}
+}
+
+func TestPrepareBulkRow(t *testing.T) {
+	useMemoryEngine(t)
+
+	user := addTestUser(t, "bulkuser")
+	if _, err := (*urlDAO).save(URL{URL: "https://example.com/", Alias: "taken"}, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		row  BulkRow
+		want URL
+		err  error
+	}{
+		{
+			name: "valid",
+			row:  BulkRow{Destination: "Example.com/a", Tags: []string{" x ", "x", "y"}, Folder: "work"},
+			want: URL{URL: "http://example.com/a", Tags: []string{"x", "y"}, Folder: "work"},
+		},
+		{
+			name: "alias",
+			row:  BulkRow{Destination: "https://example.com", Alias: "fresh"},
+			want: URL{URL: "https://example.com/", Alias: "fresh"},
+		},
+		{name: "invalid destination", row: BulkRow{Destination: "javascript:alert(1)"}, err: errInvalidDestination},
+		{name: "invalid alias", row: BulkRow{Destination: "https://example.com", Alias: "a-b"}, err: errInvalidAlias},
+		{name: "alias taken", row: BulkRow{Destination: "https://example.com", Alias: "taken"}, err: errAliasTaken},
+		{name: "alias repeated in the file", row: BulkRow{Destination: "https://example.com", Alias: "fresh"}, err: errAliasTaken},
+		{name: "invalid expiry", row: BulkRow{Destination: "https://example.com", ExpiresAt: "soon"}, err: errInvalidDate},
+		{name: "expiry in the past", row: BulkRow{Destination: "https://example.com", ExpiresAt: "2001-01-01"}, err: errInvalidDate},
+	}
+
+	aliases := map[string]bool{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := prepareBulkRow(tt.row, "lu.example.com", aliases)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("prepareBulkRow() error = %v, want %v", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(url, tt.want) {
+				t.Fatalf("prepareBulkRow() = %+v, want %+v", url, tt.want)
+			}
+		})
+	}
+
+	url, err := prepareBulkRow(BulkRow{Destination: "https://example.com", ExpiresAt: "2099-03-04"}, "", aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := time.Date(2099, 3, 5, 0, 0, 0, 0, time.UTC); url.ExpiresAt == nil || !url.ExpiresAt.Equal(want) {
+		t.Fatalf("a plain day expires at the end of it, got %v, want %v", url.ExpiresAt, want)
+	}
+}
+
+// TestGeneratedIDsIgnoreAliases makes sure a long alias does not make every later code long.
+func TestGeneratedIDsIgnoreAliases(t *testing.T) {
+	useMemoryEngine(t)
+
+	user := addTestUser(t, "aliasuser")
+
+	first, err := (*urlDAO).save(URL{URL: "https://example.com/1"}, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (*urlDAO).save(URL{URL: "https://example.com/2", Alias: "zzzzzzzzzz"}, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := (*urlDAO).save(URL{URL: "https://example.com/3"}, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next != first+1 {
+		t.Fatalf("generated id after the alias = %d, want %d", next, first+1)
+	}
+
+	_, err = (*urlDAO).saveBatch([]URL{
+		{URL: "https://example.com/4", Alias: "dup"},
+		{URL: "https://example.com/5", Alias: "dup"},
+	}, &user)
+	if !errors.Is(err, errAliasTaken) {
+		t.Fatalf("saveBatch() with a repeated alias error = %v, want %v", err, errAliasTaken)
+	}
+}
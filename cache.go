@@ -0,0 +1,373 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultCacheSize is the number of links kept by the in-process cache of each instance.
+	DefaultCacheSize = 10000
+
+	// DefaultCacheTTL is how long a link is served from the cache, changes made straight in the
+	// database are seen after it.
+	DefaultCacheTTL = time.Minute
+
+	// DefaultCacheNegativeTTL is how long unknown short codes are remembered.
+	DefaultCacheNegativeTTL = 10 * time.Second
+
+	cacheKeyPrefix = "littleu:link:"
+	// cacheInvalidationChannel tells the other instances which links changed.
+	cacheInvalidationChannel = "littleu:links:invalidate"
+	// cacheNotFound is stored in Redis for unknown short codes.
+	cacheNotFound = "-"
+)
+
+// redisLink is a link as stored in Redis, the owner is not part of the URL JSON.
+type redisLink struct {
+	URL
+	Owner string `json:"owner"`
+}
+
+type cacheEntry struct {
+	id      int
+	url     URL
+	found   bool
+	expires time.Time
+}
+
+// linkLRU is a fixed size, least recently used cache of links with expiring entries.
+type linkLRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[int]*list.Element
+}
+
+func newLinkLRU(size int) *linkLRU {
+	return &linkLRU{
+		size:    size,
+		order:   list.New(),
+		entries: map[int]*list.Element{},
+	}
+}
+
+func (l *linkLRU) get(id int, now time.Time) (cacheEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.entries[id]
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	entry := element.Value.(cacheEntry)
+	if !now.Before(entry.expires) {
+		l.order.Remove(element)
+		delete(l.entries, id)
+
+		return cacheEntry{}, false
+	}
+
+	l.order.MoveToFront(element)
+
+	return entry, true
+}
+
+func (l *linkLRU) add(entry cacheEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[entry.id]; ok {
+		element.Value = entry
+		l.order.MoveToFront(element)
+
+		return
+	}
+
+	l.entries[entry.id] = l.order.PushFront(entry)
+
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(cacheEntry).id)
+	}
+}
+
+func (l *linkLRU) remove(id int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[id]; ok {
+		l.order.Remove(element)
+		delete(l.entries, id)
+	}
+}
+
+// CachedURLDao is a read-through cache of findByID in front of another URLDao, an in-process LRU
+// backed by an optional Redis tier shared by every instance. Writes go to the wrapped DAO and
+// then invalidate the link in both tiers and, through Redis pub/sub, in the LRU of the other
+// instances. Click counters are not invalidated, cached links can lag behind them up to the TTL.
+type CachedURLDao struct {
+	URLDao
+
+	local       *linkLRU
+	client      *redis.Client
+	sharedTier  bool
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// cachedURLDao wraps dao with the cache configured by CACHE_SIZE, CACHE_TTL, CACHE_NEGATIVE_TTL
+// and CACHE_REDIS. A CACHE_SIZE or CACHE_TTL of 0 turns the cache off and returns dao.
+func cachedURLDao(dao *URLDao, client *redis.Client, config *viper.Viper) *URLDao {
+	size := config.GetInt("CACHE_SIZE")
+	ttl := config.GetDuration("CACHE_TTL")
+
+	if size <= 0 || ttl <= 0 {
+		return dao
+	}
+
+	negativeTTL := config.GetDuration("CACHE_NEGATIVE_TTL")
+	if negativeTTL <= 0 || negativeTTL > ttl {
+		negativeTTL = ttl
+	}
+
+	cached := &CachedURLDao{
+		URLDao:      *dao,
+		local:       newLinkLRU(size),
+		client:      client,
+		sharedTier:  client != nil && config.GetBool("CACHE_REDIS"),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+
+	cached.listen()
+
+	var wrapped URLDao = cached
+
+	return &wrapped
+}
+
+func cacheKey(id int) string {
+	return cacheKeyPrefix + strconv.Itoa(id)
+}
+
+// listen evicts the links other instances report as changed from the local tier, without Redis
+// there are no other instances to hear from.
+func (dao *CachedURLDao) listen() {
+	if dao.client == nil {
+		return
+	}
+
+	pubsub := dao.client.Subscribe(cacheInvalidationChannel)
+	registerCloser("link cache invalidations", pubsub.Close)
+
+	go func() {
+		for message := range pubsub.Channel() {
+			id, err := strconv.Atoi(message.Payload)
+			if err != nil {
+				continue
+			}
+
+			dao.local.remove(id)
+		}
+	}()
+}
+
+func (dao *CachedURLDao) findByID(id int) (URL, error) {
+	now := time.Now()
+
+	if entry, ok := dao.local.get(id, now); ok {
+		if !entry.found {
+			return URL{}, errorURLNotFound(id)
+		}
+
+		return entry.url, nil
+	}
+
+	if dao.sharedTier {
+		if entry, ok := dao.fromRedis(id, now); ok {
+			dao.local.add(entry)
+
+			if !entry.found {
+				return URL{}, errorURLNotFound(id)
+			}
+
+			return entry.url, nil
+		}
+	}
+
+	url, err := dao.URLDao.findByID(id)
+	if err != nil && !errors.Is(err, errNOURLFound) {
+		return url, err
+	}
+
+	entry := cacheEntry{id: id, url: url, found: err == nil, expires: now.Add(dao.ttl)}
+	if !entry.found {
+		entry.expires = now.Add(dao.negativeTTL)
+	}
+
+	dao.local.add(entry)
+
+	if dao.sharedTier {
+		dao.toRedis(entry, now)
+	}
+
+	return url, err
+}
+
+func (dao *CachedURLDao) fromRedis(id int, now time.Time) (cacheEntry, bool) {
+	value, err := dao.client.Get(cacheKey(id)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("error reading cached link %d: %v", id, err)
+		}
+
+		return cacheEntry{}, false
+	}
+
+	// The local copy expires with the shared one at the latest.
+	expires := now.Add(dao.ttl)
+	if ttl, err := dao.client.TTL(cacheKey(id)).Result(); err == nil && ttl > 0 {
+		expires = now.Add(ttl)
+	}
+
+	if value == cacheNotFound {
+		return cacheEntry{id: id, expires: expires}, true
+	}
+
+	var link redisLink
+	if err := json.Unmarshal([]byte(value), &link); err != nil {
+		log.Printf("error decoding cached link %d: %v", id, err)
+
+		return cacheEntry{}, false
+	}
+
+	link.URL.Owner = link.Owner
+
+	return cacheEntry{id: id, url: link.URL, found: true, expires: expires}, true
+}
+
+func (dao *CachedURLDao) toRedis(entry cacheEntry, now time.Time) {
+	value := cacheNotFound
+
+	if entry.found {
+		encoded, err := json.Marshal(redisLink{URL: entry.url, Owner: entry.url.Owner})
+		if err != nil {
+			log.Printf("error encoding cached link %d: %v", entry.id, err)
+
+			return
+		}
+
+		value = string(encoded)
+	}
+
+	if err := dao.client.Set(cacheKey(entry.id), value, entry.expires.Sub(now)).Err(); err != nil {
+		log.Printf("error caching link %d: %v", entry.id, err)
+	}
+}
+
+// invalidate drops the links from every tier of every instance.
+func (dao *CachedURLDao) invalidate(ids ...int) {
+	for _, id := range ids {
+		dao.local.remove(id)
+
+		if dao.sharedTier {
+			if err := dao.client.Del(cacheKey(id)).Err(); err != nil {
+				log.Printf("error invalidating cached link %d: %v", id, err)
+			}
+		}
+
+		if dao.client == nil {
+			continue
+		}
+
+		if err := dao.client.Publish(cacheInvalidationChannel, strconv.Itoa(id)).Err(); err != nil {
+			log.Printf("error publishing the invalidation of link %d: %v", id, err)
+		}
+	}
+}
+
+// save and saveBatch invalidate the new links, their codes may have been cached as unknown.
+func (dao *CachedURLDao) save(url URL, user *interface{}) (int, error) {
+	id, err := dao.URLDao.save(url, user)
+	if err == nil {
+		dao.invalidate(id)
+	}
+
+	return id, err
+}
+
+func (dao *CachedURLDao) saveBatch(urls []URL, user *interface{}) ([]int, error) {
+	ids, err := dao.URLDao.saveBatch(urls, user)
+	if err == nil {
+		dao.invalidate(ids...)
+	}
+
+	return ids, err
+}
+
+// update invalidates the new code along with the old one, it may have been cached as unknown.
+func (dao *CachedURLDao) update(id int, oldURL, newURL URL) (int, error) {
+	newID, err := dao.URLDao.update(id, oldURL, newURL)
+	if err != nil || newID == id {
+		dao.invalidate(id)
+
+		return newID, err
+	}
+
+	dao.invalidate(id, newID)
+
+	return newID, nil
+}
+
+func (dao *CachedURLDao) saveMetadata(id int, metadata LinkMetadata) error {
+	defer dao.invalidate(id)
+
+	return dao.URLDao.saveMetadata(id, metadata)
+}
+
+func (dao *CachedURLDao) saveRules(id int, rules []RedirectRule) error {
+	defer dao.invalidate(id)
+
+	return dao.URLDao.saveRules(id, rules)
+}
+
+func (dao *CachedURLDao) saveVariants(id int, variants []Variant, sticky bool) error {
+	defer dao.invalidate(id)
+
+	return dao.URLDao.saveVariants(id, variants, sticky)
+}
+
+func (dao *CachedURLDao) saveSchedule(id int, activeFrom *time.Time, schedule []ScheduledDestination) error {
+	defer dao.invalidate(id)
+
+	return dao.URLDao.saveSchedule(id, activeFrom, schedule)
+}
+
+func (dao *CachedURLDao) delete(id int) error {
+	defer dao.invalidate(id)
+
+	return dao.URLDao.delete(id)
+}
+
+func (dao *CachedURLDao) disable(id int) error {
+	defer dao.invalidate(id)
+
+	return dao.URLDao.disable(id)
+}
+
+func (dao *CachedURLDao) bulkEdit(user *interface{}, ids []int, edit LinkEdit) (int, error) {
+	defer dao.invalidate(ids...)
+
+	return dao.URLDao.bulkEdit(user, ids, edit)
+}
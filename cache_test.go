@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCachedURLDaoInvalidatesNewCodes makes sure codes looked up while unknown are found once a
+// link takes them, whether it is new or renamed.
+func TestCachedURLDaoInvalidatesNewCodes(t *testing.T) {
+	config := useMemoryEngine(t)
+	config.Set("CACHE_SIZE", 10)
+	config.Set("CACHE_TTL", time.Minute)
+
+	urlDAO = cachedURLDao(urlDAO, nil, config)
+
+	user := addTestUser(t, "cacheuser")
+
+	id, err := (*urlDAO).save(URL{URL: "https://example.org/"}, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := "renamed"
+	if _, err := (*urlDAO).findByID(shortURLToID(renamed, chars)); !errors.Is(err, errNOURLFound) {
+		t.Fatalf("findByID(%q) error = %v, want %v", renamed, err, errNOURLFound)
+	}
+
+	newID, err := (*urlDAO).update(id, URL{URL: idToShortURL(id, chars)}, URL{URL: renamed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := (*urlDAO).findByID(newID)
+	if err != nil || url.URL != "https://example.org/" {
+		t.Fatalf("findByID(%q) = %+v, %v, want the renamed link", renamed, url, err)
+	}
+
+	if _, err := (*urlDAO).findByID(id); !errors.Is(err, errNOURLFound) {
+		t.Fatalf("findByID(%d) error = %v, the old code is still cached", id, err)
+	}
+}
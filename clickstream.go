@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// clickChannelPrefix is the Redis pub/sub channel prefix, one channel per link owner so a
+	// viewer of every link needs a single subscription.
+	clickChannelPrefix = "littleu:clicks:"
+
+	// liveKeepAlive is how often an idle event stream gets a comment so proxies keep it open.
+	liveKeepAlive = 15 * time.Second
+)
+
+// ClickEvent is what live viewers receive for every recorded click.
+type ClickEvent struct {
+	Time     time.Time `json:"time"`
+	ShortURL string    `json:"short_url"`
+	Country  string    `json:"country"`
+	Referrer string    `json:"referrer"`
+	Device   string    `json:"device"`
+	Source   string    `json:"source,omitempty"`
+	Rule     string    `json:"rule,omitempty"`
+	Variant  string    `json:"variant,omitempty"`
+	Bot      bool      `json:"bot"`
+}
+
+func clickChannel(owner string) string {
+	return clickChannelPrefix + owner
+}
+
+func newClickEvent(click *ClickInfo) ClickEvent {
+	headers := http.Header(click.Headers)
+
+	event := ClickEvent{
+		Time:     time.Now(),
+		ShortURL: click.ShortURL,
+		Country:  click.Location.Country,
+		Referrer: headers.Get("Referer"),
+		Device:   deviceType(headers.Get("User-Agent")),
+		Source:   click.Source,
+		Rule:     click.Rule,
+		Variant:  click.Variant,
+		Bot:      click.Bot,
+	}
+
+	if click.Bot {
+		event.Device = deviceBot
+	}
+
+	return event
+}
+
+// publishClick fans the click out through Redis so viewers connected to any littleu instance
+// get it. There are no live viewers without Redis, nor for links without an owner.
+func publishClick(click *ClickInfo, owner string) {
+	if redisClient == nil || owner == "" {
+		return
+	}
+
+	payload, err := json.Marshal(newClickEvent(click))
+	if err != nil {
+		log.Printf("error encoding click event: %v", err)
+
+		return
+	}
+
+	if err := redisClient.Publish(clickChannel(owner), payload).Err(); err != nil {
+		log.Printf("error publishing click event: %v", err)
+	}
+}
+
+// streamClicks relays the click events published for the links of owner which match to the
+// client as Server-Sent Events until it disconnects.
+func streamClicks(c *gin.Context, owner string, match func(ClickEvent) bool) {
+	if redisClient == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": errorRedisNotConfigured("live clicks").Error()})
+
+		return
+	}
+
+	pubsub := redisClient.Subscribe(clickChannel(owner))
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	messages := pubsub.Channel()
+	keepAlive := time.NewTicker(liveKeepAlive)
+
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-shuttingDown:
+			return false
+		case <-keepAlive.C:
+			_, err := w.Write([]byte(": keep-alive\n\n"))
+
+			return err == nil
+		case message, ok := <-messages:
+			if !ok {
+				return false
+			}
+
+			var event ClickEvent
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil || !match(event) {
+				return true
+			}
+
+			c.SSEvent("click", event)
+
+			return true
+		}
+	})
+}
+
+// liveAccountClicks streams the clicks on every link of the logged in user.
+func liveAccountClicks(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	owner, ok := userKey(&userFound)
+	if !ok {
+		_ = c.AbortWithError(http.StatusInternalServerError, errorIncompatibleTypes())
+
+		return
+	}
+
+	filter, _ := statsFilter(c)
+
+	// Users without links get an open stream too, clicks show up as soon as they create one.
+	streamClicks(c, owner, func(event ClickEvent) bool {
+		return filter.IncludeBots || !event.Bot
+	})
+}
+
+// liveLinkClicks streams the clicks of a single link owned by the logged in user.
+func liveLinkClicks(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	shortURL := c.Param("url")
+	shortID := shortURLToID(shortURL, chars)
+
+	owned, err := userOwnsURL(&userFound, shortID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"message": errorURLNotFound(shortID).Error()})
+
+		return
+	}
+
+	owner, ok := userKey(&userFound)
+	if !ok {
+		_ = c.AbortWithError(http.StatusInternalServerError, errorIncompatibleTypes())
+
+		return
+	}
+
+	filter, _ := statsFilter(c)
+
+	streamClicks(c, owner, func(event ClickEvent) bool {
+		return (filter.IncludeBots || !event.Bot) && shortURLToID(event.ShortURL, chars) == shortID
+	})
+}
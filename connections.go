@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis"
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultRedisDSN is used by the redis session store when REDIS_DSN is not set.
+const DefaultRedisDSN = "localhost:6379"
+
+// redisDSN returns REDIS_DSN, the default one when Redis is needed and it is not set.
+func redisDSN(config *viper.Viper) string {
+	if dsn := config.GetString("REDIS_DSN"); dsn != "" {
+		return dsn
+	}
+
+	return DefaultRedisDSN
+}
+
+// connectRedis connects to Redis when the session store needs it or REDIS_DSN is set, otherwise
+// it returns a nil client and API tokens, live clicks and cache invalidations across instances
+// are not available.
+func connectRedis(config *viper.Viper) (*redis.Client, error) {
+	if config.GetString("REDIS_DSN") == "" && sessionStoreName(config) != sessionStoreRedis {
+		return nil, nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr: redisDSN(config),
+	})
+
+	if err := client.Ping().Err(); err != nil {
+		_ = client.Close()
+
+		return nil, fmt.Errorf("error connecting to redis: %w", err)
+	}
+
+	registerCloser("redis", client.Close)
+
+	return client, nil
+}
+
+// connectMongo connects to MONGO_URI when Mongo is the database engine or the session store, nil
+// is returned otherwise.
+func connectMongo(config *viper.Viper) (*mongo.Client, error) {
+	if config.GetString("dbengine") != "mongo" && sessionStoreName(config) != sessionStoreMongo {
+		return nil, nil
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.GetString("MONGO_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to mongo: %w", err)
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+
+		return nil, fmt.Errorf("error connecting to mongo: %w", err)
+	}
+
+	registerCloser("mongo", func() error {
+		return client.Disconnect(context.Background())
+	})
+
+	return client, nil
+}
+
+// connectPostgres opens the pool of POSTGRES_DSN when Postgres is the database engine or the
+// session store, every DAO and the session store share it. nil is returned otherwise.
+func connectPostgres(config *viper.Viper) (*sql.DB, error) {
+	if config.GetString("dbengine") != "postgresql" && sessionStoreName(config) != sessionStorePostgres {
+		return nil, nil
+	}
+
+	dsn := config.GetString("POSTGRES_DSN")
+	if dsn == "" {
+		return nil, errors.New("POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to postgresql: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("error connecting to postgresql: %w", err)
+	}
+
+	registerCloser("postgresql", db.Close)
+
+	return db, nil
+}
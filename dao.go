@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/binary"
 	"log"
+	"time"
 
-	_ "github.com/lib/pq"
 	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -24,9 +25,31 @@ func (s *randGenSrc) Uint64() (value uint64) {
 // URLDao ...
 type URLDao interface {
 	save(url URL, user *interface{}) (int, error)
+	// saveBatch saves all the urls or none of them where the engine allows it.
+	saveBatch(urls []URL, user *interface{}) ([]int, error)
 	update(id int, oldURL, newURL URL) (int, error)
 	findByID(id int) (URL, error)
-	findAllByUser(id *interface{}) ([]URLStat, error)
+	findAllByUser(id *interface{}, filter LinkFilter) ([]URLStat, error)
+	// query returns up to q.Limit links of user in the order asked for, starting after q.After.
+	query(user *interface{}, q LinkQuery) ([]URLStat, error)
+	recordClick(id int, at time.Time) error
+	saveMetadata(id int, metadata LinkMetadata) error
+	saveRules(id int, rules []RedirectRule) error
+	saveVariants(id int, variants []Variant, sticky bool) error
+	saveSchedule(id int, activeFrom *time.Time, schedule []ScheduledDestination) error
+	// ping checks the engine can be reached, for the readiness probe.
+	ping(ctx context.Context) error
+	delete(id int) error
+	ownerOf(id int) (string, error)
+	findAll() ([]URLStat, error)
+	disable(id int) error
+	// findActiveByUser returns the short ID of a live link of user pointing to url, that is a link
+	// which is not disabled, has not expired and whose active from date, if any, has passed.
+	findActiveByUser(user *interface{}, url string) (int, bool, error)
+	// findTagsAndFolders returns the sorted tags and folders used by the links of user.
+	findTagsAndFolders(user *interface{}) ([]string, []string, error)
+	// bulkEdit applies edit to the links of user among ids and returns how many were changed.
+	bulkEdit(user *interface{}, ids []int, edit LinkEdit) (int, error)
 }
 
 // UserDAO ....
@@ -34,18 +57,57 @@ type UserDAO interface {
 	addUser(username, password string) (interface{}, error)
 	userExists(username string) (bool, error)
 	findByUsername(username string) (interface{}, error)
+	// findUsernameByKey returns the username of the user with the given userKey.
+	findUsernameByKey(key string) (string, error)
 	validateUserAndPassword(username, password string) (bool, error)
 	findAll() ([]interface{}, error)
 }
 
 // StatsDAO ...
 type StatsDAO interface {
-	save(shortURL string, headers *map[string][]string, user *interface{}) (int, error)
-	findByShortID(id int) ([]interface{}, error)
-	findAllByUser(user *interface{}) ([]interface{}, error)
+	// save records a click on a link of owner, the userKey of the user the link belongs to. The
+	// per user methods return the clicks on the links of the user, whoever made them.
+	save(click *ClickInfo, owner string) (int, error)
+	ping(ctx context.Context) error
+	findByShortID(id int, filter StatsFilter) ([]Click, error)
+	findAllByUser(user *interface{}, filter StatsFilter) ([]Click, error)
+	streamByShortID(id int, filter StatsFilter, fn func(Click) error) error
+	streamByUser(user *interface{}, filter StatsFilter, fn func(Click) error) error
+	// countByShortIDs counts the clicks on each of the links in a single query.
+	countByShortIDs(ids []int, filter StatsFilter) (map[int]ClickCount, error)
 }
 
-func factoryStatsDao(mongoClient *mongo.Client, config *viper.Viper) *StatsDAO {
+// WebhookDAO ...
+type WebhookDAO interface {
+	save(hook Webhook) error
+	delete(userKey, id string) error
+	findByID(userKey, id string) (Webhook, error)
+	findAllByUser(userKey string) ([]Webhook, error)
+	saveDelivery(delivery WebhookDelivery) error
+	findDeliveries(webhookID string, limit int) ([]WebhookDelivery, error)
+}
+
+// BlocklistDAO ...
+type BlocklistDAO interface {
+	save(entry BlocklistEntry) error
+	findAll() ([]BlocklistEntry, error)
+}
+
+// collectClicks gathers every click a stream call produces, it backs the find methods of
+// StatsDAO implementations.
+func collectClicks(stream func(fn func(Click) error) error) ([]Click, error) {
+	clicks := []Click{}
+
+	err := stream(func(click Click) error {
+		clicks = append(clicks, click)
+
+		return nil
+	})
+
+	return clicks, err
+}
+
+func factoryStatsDao(mongoClient *mongo.Client, db *sql.DB, config *viper.Viper) *StatsDAO {
 	var dao StatsDAO
 
 	engine := config.GetString("dbengine")
@@ -63,16 +125,6 @@ func factoryStatsDao(mongoClient *mongo.Client, config *viper.Viper) *StatsDAO {
 			ctx:        ctx,
 		}
 	case "postgresql":
-		dsn := config.GetString("POSTGRES_DSN")
-		if dsn == "" {
-			log.Fatalf("POSTGRES_DSN environtment variable is not set")
-		}
-
-		db, err := sql.Open("postgres", dsn)
-		if err != nil {
-			log.Fatal(err)
-		}
-
 		dao = StatsPostgresqlImpl{
 			db,
 		}
@@ -85,7 +137,7 @@ func factoryStatsDao(mongoClient *mongo.Client, config *viper.Viper) *StatsDAO {
 	return &dao
 }
 
-func factoryURLDao(mongoClient *mongo.Client, config *viper.Viper) *URLDao {
+func factoryURLDao(mongoClient *mongo.Client, db *sql.DB, config *viper.Viper) *URLDao {
 	var dao URLDao
 
 	engine := config.GetString("dbengine")
@@ -94,31 +146,35 @@ func factoryURLDao(mongoClient *mongo.Client, config *viper.Viper) *URLDao {
 	case "memory":
 		dao = InMemoryURLDAOImpl{
 			DB: &memoryDB{
-				db: map[int]string{},
+				db:      map[int]URL{},
+				owners:  map[int]string{},
+				byOwner: map[string]map[string]int{},
 			},
 		}
 	case "mongo":
 		var collection *mongo.Collection
 		collection = mongoClient.Database("littleu").Collection("url")
-		dao = MongoDBURLDAOImpl{
+		mongoDAO := MongoDBURLDAOImpl{
 			collection: collection,
 			ctx:        ctx,
 		}
 
-	case "postgresql":
-		dsn := config.GetString("POSTGRES_DSN")
-		if dsn == "" {
-			log.Fatalf("POSTGRES_DSN environtment variable is not set")
+		if err := mongoDAO.ensureIndexes(); err != nil {
+			log.Printf("error creating url indexes: %v", err)
 		}
 
-		db, err := sql.Open("postgres", dsn)
-		if err != nil {
-			log.Fatal(err)
-		}
+		dao = mongoDAO
 
-		dao = PostgresqlURLDAOImpl{
+	case "postgresql":
+		psqlDAO := PostgresqlURLDAOImpl{
 			db,
 		}
+
+		if err := psqlDAO.ensureIndexes(); err != nil {
+			log.Printf("error creating url indexes: %v", err)
+		}
+
+		dao = psqlDAO
 	default:
 		log.Fatalf("error: wrong engine: %s", engine)
 
@@ -128,7 +184,7 @@ func factoryURLDao(mongoClient *mongo.Client, config *viper.Viper) *URLDao {
 	return &dao
 }
 
-func factoryUserDAO(mongoClient *mongo.Client, config *viper.Viper) *UserDAO {
+func factoryUserDAO(mongoClient *mongo.Client, db *sql.DB, config *viper.Viper) *UserDAO {
 	var userDAO UserDAO
 
 	engine := config.GetString("dbengine")
@@ -148,25 +204,75 @@ func factoryUserDAO(mongoClient *mongo.Client, config *viper.Viper) *UserDAO {
 			ctx:        ctx,
 		}
 	case "postgresql":
-		dsn := config.GetString("POSTGRES_DSN")
-		if dsn == "" {
-			log.Fatalf("POSTGRES_DSN environtment variable is not set")
+		userDAO = PostgresqlUserImpl{
+			db,
 		}
 
-		db, err := sql.Open("postgres", dsn)
-		if err != nil {
-			log.Fatal(err)
-		}
+	default:
+		log.Fatalf("error: wrong engine: %s", engine)
 
-		userDAO = PostgresqlUserImpl{
+		return nil
+	}
+
+	return &userDAO
+}
+
+func factoryWebhookDAO(mongoClient *mongo.Client, db *sql.DB, config *viper.Viper) *WebhookDAO {
+	var dao WebhookDAO
+
+	engine := config.GetString("dbengine")
+
+	switch engine {
+	case "memory":
+		dao = WebhookMemoryImpl{
+			db: &webhookMemoryDB{
+				hooks:      map[string]Webhook{},
+				deliveries: map[string][]WebhookDelivery{},
+			},
+		}
+	case "mongo":
+		dao = WebhookMongoImpl{
+			hooks:      mongoClient.Database("littleu").Collection("webhooks"),
+			deliveries: mongoClient.Database("littleu").Collection("webhook_deliveries"),
+			ctx:        ctx,
+		}
+	case "postgresql":
+		dao = WebhookPostgresqlImpl{
 			db,
 		}
+	default:
+		log.Fatalf("error: wrong engine: %s", engine)
+
+		return nil
+	}
+
+	return &dao
+}
+
+func factoryBlocklistDAO(mongoClient *mongo.Client, db *sql.DB, config *viper.Viper) *BlocklistDAO {
+	var dao BlocklistDAO
+
+	engine := config.GetString("dbengine")
 
+	switch engine {
+	case "memory":
+		dao = BlocklistMemoryImpl{
+			db: &blocklistMemoryDB{},
+		}
+	case "mongo":
+		dao = BlocklistMongoImpl{
+			collection: mongoClient.Database("littleu").Collection("blocklist"),
+			ctx:        ctx,
+		}
+	case "postgresql":
+		dao = BlocklistPostgresqlImpl{
+			db,
+		}
 	default:
 		log.Fatalf("error: wrong engine: %s", engine)
 
 		return nil
 	}
 
-	return &userDAO
+	return &dao
 }
@@ -13,6 +13,24 @@ var (
 	errUsernameFieldEmpty = errors.New("username cannot be empty")
 	errKeyNotFoundInDB    = errors.New("key not found")
 	errUpdatingURL        = errors.New("updating url")
+	errInvalidBotPattern  = errors.New("invalid bot signature")
+	errInvalidDate        = errors.New("invalid date")
+	errWebhookNotFound    = errors.New("webhook not found")
+	errInvalidWebhook     = errors.New("invalid webhook")
+	errInvalidQROption    = errors.New("invalid qr code option")
+	errInvalidDestination = errors.New("invalid destination url")
+	errRedirectLoop       = errors.New("redirect loop")
+	errBlockedDestination = errors.New("blocked destination")
+	errInvalidBlocklist   = errors.New("invalid blocklist entry")
+	errAliasTaken         = errors.New("alias already taken")
+	errInvalidAlias       = errors.New("invalid alias")
+	errInvalidBulkFile    = errors.New("invalid bulk file")
+	errInvalidFolder      = errors.New("invalid folder")
+	errInvalidLinkQuery   = errors.New("invalid link query")
+	errInvalidRule        = errors.New("invalid redirect rule")
+	errInvalidVariant     = errors.New("invalid variant")
+	errInvalidSchedule    = errors.New("invalid schedule")
+	errRedisNotConfigured = errors.New("redis is not configured")
 )
 
 func errorURLNotFound(url int) error {
@@ -42,3 +60,83 @@ func errorKeyNotFoundInDB(id int) error {
 func errorUpdatingURL(id int) error {
 	return fmt.Errorf("errUpdatingURL %w : %d id", errUpdatingURL, id)
 }
+
+func errorInvalidBotSignature(signature string, err error) error {
+	return fmt.Errorf("errInvalidBotPattern %w : %s (%v)", errInvalidBotPattern, signature, err)
+}
+
+func errorInvalidDate(value string) error {
+	return fmt.Errorf("errInvalidDate %w : %s, expected YYYY-MM-DD or RFC 3339", errInvalidDate, value)
+}
+
+func errorWebhookNotFound(id string) error {
+	return fmt.Errorf("errWebhookNotFound %w : %s", errWebhookNotFound, id)
+}
+
+func errorInvalidWebhook(reason string) error {
+	return fmt.Errorf("errInvalidWebhook %w : %s", errInvalidWebhook, reason)
+}
+
+func errorInvalidQROption(option, value string) error {
+	return fmt.Errorf("errInvalidQROption %w : %s=%s", errInvalidQROption, option, value)
+}
+
+func errorInvalidDestination(reason string) error {
+	return fmt.Errorf("%w: %s", errInvalidDestination, reason)
+}
+
+func errorRedirectLoop(url string) error {
+	return fmt.Errorf("%w: %s points back to itself", errRedirectLoop, url)
+}
+
+func errorBlockedDestination(entry string) error {
+	return fmt.Errorf("%w: the url matches the blocklist entry %s", errBlockedDestination, entry)
+}
+
+func errorInvalidBlocklistEntry(entry, reason string) error {
+	return fmt.Errorf("%w: %s, %s", errInvalidBlocklist, entry, reason)
+}
+
+func errorAliasTaken(alias string) error {
+	return fmt.Errorf("%w: %s, pick a different one", errAliasTaken, alias)
+}
+
+func errorInvalidAlias(alias, reason string) error {
+	return fmt.Errorf("%w: %s, %s", errInvalidAlias, alias, reason)
+}
+
+func errorInvalidBulkFile(reason string) error {
+	return fmt.Errorf("%w: %s", errInvalidBulkFile, reason)
+}
+
+func errorExpiryInPast(value string) error {
+	return fmt.Errorf("errInvalidDate %w : %s is in the past", errInvalidDate, value)
+}
+
+func errorInvalidFolder(reason string) error {
+	return fmt.Errorf("%w: %s", errInvalidFolder, reason)
+}
+
+func errorInvalidLinkQuery(param, value string) error {
+	return fmt.Errorf("errInvalidLinkQuery %w : %s=%s", errInvalidLinkQuery, param, value)
+}
+
+func errorInvalidCursor(value string) error {
+	return fmt.Errorf("errInvalidLinkQuery %w : cursor %s", errInvalidLinkQuery, value)
+}
+
+func errorInvalidRule(rule int, reason string) error {
+	return fmt.Errorf("%w: rule %d, %s", errInvalidRule, rule, reason)
+}
+
+func errorInvalidVariant(variant int, reason string) error {
+	return fmt.Errorf("%w: variant %d, %s", errInvalidVariant, variant, reason)
+}
+
+func errorInvalidSchedule(entry int, reason string) error {
+	return fmt.Errorf("%w: entry %d, %s", errInvalidSchedule, entry, reason)
+}
+
+func errorRedisNotConfigured(feature string) error {
+	return fmt.Errorf("%w: %s needs REDIS_DSN", errRedisNotConfigured, feature)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoLocation ...
+type GeoLocation struct {
+	Country string `json:"country"`
+	Region  string `json:"region"`
+	City    string `json:"city"`
+}
+
+// GeoIPResolver resolves client IPs to locations using a local MaxMind database, no network
+// lookups are involved.
+type GeoIPResolver struct {
+	db             *geoip2.Reader
+	trustedProxies []*net.IPNet
+}
+
+func newGeoIPResolver(dbFile string, trustedProxies []string) (*GeoIPResolver, error) {
+	resolver := &GeoIPResolver{}
+
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		// A single address is trusted as a /32 (or /128) network.
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing trusted proxy %q: %w", proxy, err)
+		}
+
+		resolver.trustedProxies = append(resolver.trustedProxies, network)
+	}
+
+	if dbFile == "" {
+		return resolver, nil
+	}
+
+	db, err := geoip2.Open(dbFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening GeoIP database: %w", err)
+	}
+
+	resolver.db = db
+
+	return resolver, nil
+}
+
+func (g *GeoIPResolver) isTrustedProxy(ip net.IP) bool {
+	for _, network := range g.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP returns the address of the visitor. X-Forwarded-For is only honoured when the request
+// comes from a trusted proxy, and it is walked from right to left skipping the trusted hops.
+func (g *GeoIPResolver) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !g.isTrustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+
+		if !g.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// close releases the database, if any.
+func (g *GeoIPResolver) close() error {
+	if g.db == nil {
+		return nil
+	}
+
+	return g.db.Close()
+}
+
+// locate returns the location of the IP, the zero GeoLocation is returned when no database is
+// configured or the address is unknown.
+func (g *GeoIPResolver) locate(ip net.IP) GeoLocation {
+	if g.db == nil || ip == nil {
+		return GeoLocation{}
+	}
+
+	record, err := g.db.City(ip)
+	if err != nil {
+		return GeoLocation{}
+	}
+
+	location := GeoLocation{
+		Country: record.Country.IsoCode,
+		City:    record.City.Names["en"],
+	}
+
+	if len(record.Subdivisions) > 0 {
+		location.Region = record.Subdivisions[0].Names["en"]
+	}
+
+	return location
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ginrender "github.com/gin-gonic/gin/render"
+)
+
+const (
+	// readinessTimeout bounds every readiness check, probes are usually given a second or two.
+	readinessTimeout = 2 * time.Second
+
+	statusOK          = "ok"
+	statusDegraded    = "degraded"
+	statusUnavailable = "unavailable"
+)
+
+// readinessTemplates are the pages littleu cannot serve links without.
+var readinessTemplates = []string{"index.html", "error5xx.html", "notyet.html", "flagged.html", "preview.html"}
+
+// readinessCheck is a dependency checked by /readyz. Failing required checks make the instance
+// unavailable, failing optional ones only degrade it.
+type readinessCheck struct {
+	name     string
+	required bool
+	check    func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a readiness check.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "database", required: true, check: func(ctx context.Context) error {
+			return (*urlDAO).ping(ctx)
+		}},
+		{name: "templates", required: true, check: func(context.Context) error {
+			return checkTemplates()
+		}},
+		{name: "stats", check: func(ctx context.Context) error {
+			return (*statsDAO).ping(ctx)
+		}},
+		{name: "metadata", check: func(context.Context) error {
+			return checkMetadataFetcher()
+		}},
+	}
+
+	// Redis is only connected to when something needs it, see connectRedis.
+	if redisClient != nil {
+		checks = append(checks, readinessCheck{name: "redis", required: true, check: func(context.Context) error {
+			return redisClient.Ping().Err()
+		}})
+	}
+
+	return checks
+}
+
+// checkTemplates makes sure the pages were loaded, templates are only parsed once in release mode.
+func checkTemplates() error {
+	templates, ok := router.HTMLRender.(ginrender.HTMLProduction)
+	if !ok {
+		return nil
+	}
+
+	if templates.Template == nil {
+		return fmt.Errorf("no templates loaded")
+	}
+
+	for _, name := range readinessTemplates {
+		if templates.Template.Lookup(name) == nil {
+			return fmt.Errorf("template %s not loaded", name)
+		}
+	}
+
+	return nil
+}
+
+// checkMetadataFetcher fails when new links would not get their metadata.
+func checkMetadataFetcher() error {
+	metadataFetcher.mu.RLock()
+	defer metadataFetcher.mu.RUnlock()
+
+	if metadataFetcher.stopped {
+		return fmt.Errorf("metadata fetcher stopped")
+	}
+
+	if len(metadataFetcher.queue) == cap(metadataFetcher.queue) {
+		return fmt.Errorf("metadata queue full")
+	}
+
+	return nil
+}
+
+// runReadinessChecks runs the checks concurrently and returns their results along with the
+// overall status.
+func runReadinessChecks(ctx context.Context, checks []readinessCheck) (string, map[string]CheckResult) {
+	results := make(map[string]CheckResult, len(checks))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for _, c := range checks {
+		wg.Add(1)
+
+		go func(c readinessCheck) {
+			defer wg.Done()
+
+			result := CheckResult{Status: statusOK, Required: c.required}
+			start := time.Now()
+
+			errc := make(chan error, 1)
+			go func() { errc <- c.check(ctx) }()
+
+			var err error
+
+			select {
+			case err = <-errc:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+
+			if err != nil {
+				result.Status = statusUnavailable
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[c.name] = result
+			mu.Unlock()
+		}(c)
+	}
+
+	wg.Wait()
+
+	status := statusOK
+
+	for _, result := range results {
+		if result.Status == statusOK {
+			continue
+		}
+
+		if result.Required {
+			return statusUnavailable, results
+		}
+
+		status = statusDegraded
+	}
+
+	return status, results
+}
+
+// healthz is the liveness probe, it only tells the process is serving requests.
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": statusOK})
+}
+
+// readyz is the readiness probe. Degraded instances keep getting traffic, unavailable ones and
+// instances shutting down answer 503.
+func readyz(c *gin.Context) {
+	select {
+	case <-shuttingDown:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": statusUnavailable, "error": "shutting down"})
+
+		return
+	default:
+	}
+
+	checkCtx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	status, results := runReadinessChecks(checkCtx, readinessChecks())
+
+	code := http.StatusOK
+	if status == statusUnavailable {
+		code = http.StatusServiceUnavailable
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": results,
+	})
+}
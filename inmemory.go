@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type memoryDB struct {
-	db            map[int]string
+	db     map[int]URL
+	owners map[int]string
+	// map[owner:string]map[url:string]shortID:int
+	byOwner       map[string]map[string]int
 	autoIncrement int
 }
 
@@ -22,6 +29,26 @@ type InMemoryUserDAOImpl struct {
 	rndIDGen randGenSrc
 }
 
+type webhookMemoryDB struct {
+	hooks map[string]Webhook
+	// map[webhookID:string][]WebhookDelivery
+	deliveries map[string][]WebhookDelivery
+}
+
+// WebhookMemoryImpl ...
+type WebhookMemoryImpl struct {
+	db *webhookMemoryDB
+}
+
+type blocklistMemoryDB struct {
+	entries []BlocklistEntry
+}
+
+// BlocklistMemoryImpl ...
+type BlocklistMemoryImpl struct {
+	db *blocklistMemoryDB
+}
+
 // StatsDAOMemoryImpl ...
 type StatsDAOMemoryImpl struct {
 	// map[userID:int][]StatsInMemory
@@ -29,43 +56,236 @@ type StatsDAOMemoryImpl struct {
 }
 
 func (im InMemoryURLDAOImpl) save(url URL, user *interface{}) (int, error) {
+	ids, err := im.saveBatch([]URL{url}, user)
+	if err != nil {
+		return -1, err
+	}
+
+	return ids[0], nil
+}
+
+func (im InMemoryURLDAOImpl) saveBatch(urls []URL, user *interface{}) ([]int, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	im.DB.autoIncrement++
-	id := im.DB.autoIncrement
-	im.DB.db[id] = url.URL
+	ids := make([]int, len(urls))
+	aliases := map[int]bool{}
+
+	for i, url := range urls {
+		if url.Alias == "" {
+			continue
+		}
+
+		id := shortURLToID(url.Alias, chars)
+		if _, taken := im.DB.db[id]; taken || aliases[id] {
+			return nil, errorAliasTaken(url.Alias)
+		}
+
+		ids[i] = id
+		aliases[id] = true
+	}
+
+	owner, hasOwner := userKey(user)
+
+	for i, url := range urls {
+		if url.Alias == "" {
+			// Aliases can land anywhere in the sequence, those ids are skipped.
+			for {
+				im.DB.autoIncrement++
+				if _, taken := im.DB.db[im.DB.autoIncrement]; !taken && !aliases[im.DB.autoIncrement] {
+					break
+				}
+			}
+
+			ids[i] = im.DB.autoIncrement
+		}
+
+		url.Alias = ""
+		url.Disabled = false
+		url.CreatedAt = time.Now()
+		url.Clicks = 0
+		url.LastClickAt = nil
+		url.LinkMetadata = LinkMetadata{}
+		url.Rules = nil
+		url.Variants = nil
+		url.StickyVariants = false
+		url.Schedule = nil
+		im.DB.db[ids[i]] = url
+
+		if hasOwner {
+			im.DB.owners[ids[i]] = owner
+
+			if im.DB.byOwner[owner] == nil {
+				im.DB.byOwner[owner] = map[string]int{}
+			}
+
+			im.DB.byOwner[owner][url.URL] = ids[i]
+		}
+	}
 
-	return id, nil
+	return ids, nil
 }
 
-func (im InMemoryURLDAOImpl) findAllByUser(user *interface{}) ([]URLStat, error) {
-	_, ok := (*user).(*UserInMemory)
+func (im InMemoryURLDAOImpl) findAllByUser(user *interface{}, filter LinkFilter) ([]URLStat, error) {
+	owner, ok := userKey(user)
 	if !ok {
 		return []URLStat{}, errorIncompatibleTypes()
 	}
 
-	// shortID:int, url:string
-	var urls []URLStat
+	mu.RLock()
+	defer mu.RUnlock()
+
+	urls := []URLStat{}
 
-	// dummy impl...
 	for shortID, url := range im.DB.db {
-		urls = append(urls, URLStat{
-			ShortID: shortID,
-			Url:     url,
-		})
+		if im.DB.owners[shortID] != owner || !filter.matches(url) {
+			continue
+		}
+
+		urls = append(urls, toURLStatMemory(shortID, url))
 	}
 
 	return urls, nil
 }
 
-func (im InMemoryURLDAOImpl) findByID(id int) (URL, error) {
-	u, found := im.DB.db[id]
-	if found {
-		url := URL{
-			URL: u,
+func toURLStatMemory(shortID int, url URL) URLStat {
+	return URLStat{
+		ShortID:      shortID,
+		Url:          url.URL,
+		Tags:         url.Tags,
+		Folder:       url.Folder,
+		CreatedAt:    url.CreatedAt,
+		Clicks:       url.Clicks,
+		LastClickAt:  url.LastClickAt,
+		LinkMetadata: url.LinkMetadata,
+	}
+}
+
+func (im InMemoryURLDAOImpl) query(user *interface{}, q LinkQuery) ([]URLStat, error) {
+	urls, err := im.findAllByUser(user, q.LinkFilter)
+	if err != nil {
+		return urls, err
+	}
+
+	cursor := func(u URLStat) LinkCursor {
+		return LinkCursor{Value: q.sortValue(u), ShortID: u.ShortID}
+	}
+
+	matching := []URLStat{}
+
+	for _, u := range urls {
+		if !q.matchesSearch(u.ShortID, URL{URL: u.Url, LinkMetadata: u.LinkMetadata}) {
+			continue
 		}
 
+		if q.After != nil && !q.before(*q.After, cursor(u)) {
+			continue
+		}
+
+		matching = append(matching, u)
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		return q.before(cursor(matching[i]), cursor(matching[j]))
+	})
+
+	if len(matching) > q.Limit {
+		matching = matching[:q.Limit]
+	}
+
+	return matching, nil
+}
+
+func (im InMemoryURLDAOImpl) recordClick(id int, at time.Time) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	url, ok := im.DB.db[id]
+	if !ok {
+		return errorURLNotFound(id)
+	}
+
+	url.Clicks++
+	url.LastClickAt = &at
+	im.DB.db[id] = url
+
+	return nil
+}
+
+func (im InMemoryURLDAOImpl) saveMetadata(id int, metadata LinkMetadata) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	url, ok := im.DB.db[id]
+	if !ok {
+		return errorURLNotFound(id)
+	}
+
+	url.LinkMetadata = metadata
+	im.DB.db[id] = url
+
+	return nil
+}
+
+func (im InMemoryURLDAOImpl) saveRules(id int, rules []RedirectRule) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	url, ok := im.DB.db[id]
+	if !ok {
+		return errorURLNotFound(id)
+	}
+
+	url.Rules = append([]RedirectRule(nil), rules...)
+	im.DB.db[id] = url
+
+	return nil
+}
+
+func (im InMemoryURLDAOImpl) saveVariants(id int, variants []Variant, sticky bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	url, ok := im.DB.db[id]
+	if !ok {
+		return errorURLNotFound(id)
+	}
+
+	url.Variants = append([]Variant(nil), variants...)
+	url.StickyVariants = sticky
+	im.DB.db[id] = url
+
+	return nil
+}
+
+func (im InMemoryURLDAOImpl) saveSchedule(id int, activeFrom *time.Time, schedule []ScheduledDestination) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	url, ok := im.DB.db[id]
+	if !ok {
+		return errorURLNotFound(id)
+	}
+
+	url.ActiveFrom = activeFrom
+	url.Schedule = append([]ScheduledDestination(nil), schedule...)
+	im.DB.db[id] = url
+
+	return nil
+}
+
+// ping always succeeds, the links live in the process.
+func (im InMemoryURLDAOImpl) ping(_ context.Context) error {
+	return nil
+}
+
+func (im InMemoryURLDAOImpl) findByID(id int) (URL, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if url, found := im.DB.db[id]; found {
+		url.Owner = im.DB.owners[id]
+
 		return url, nil
 	}
 
@@ -86,9 +306,150 @@ func (im InMemoryURLDAOImpl) update(id int, oldURL, newURL URL) (int, error) {
 	im.DB.db[newID] = url
 	delete(im.DB.db, id)
 
+	if owner, ok := im.DB.owners[id]; ok {
+		im.DB.owners[newID] = owner
+		delete(im.DB.owners, id)
+
+		if im.DB.byOwner[owner][url.URL] == id {
+			im.DB.byOwner[owner][url.URL] = newID
+		}
+	}
+
 	return newID, nil
 }
 
+func (im InMemoryURLDAOImpl) delete(id int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := im.DB.db[id]; !ok {
+		return errorURLNotFound(id)
+	}
+
+	owner, url := im.DB.owners[id], im.DB.db[id].URL
+	if im.DB.byOwner[owner][url] == id {
+		delete(im.DB.byOwner[owner], url)
+	}
+
+	delete(im.DB.db, id)
+	delete(im.DB.owners, id)
+
+	return nil
+}
+
+func (im InMemoryURLDAOImpl) ownerOf(id int) (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if _, ok := im.DB.db[id]; !ok {
+		return "", errorURLNotFound(id)
+	}
+
+	return im.DB.owners[id], nil
+}
+
+func (im InMemoryURLDAOImpl) findAll() ([]URLStat, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	urls := make([]URLStat, 0, len(im.DB.db))
+
+	for shortID, url := range im.DB.db {
+		urls = append(urls, toURLStatMemory(shortID, url))
+	}
+
+	return urls, nil
+}
+
+func (im InMemoryURLDAOImpl) findActiveByUser(user *interface{}, url string) (int, bool, error) {
+	owner, ok := userKey(user)
+	if !ok {
+		return -1, false, errorIncompatibleTypes()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	id, found := im.DB.byOwner[owner][url]
+	if !found {
+		return -1, false, nil
+	}
+
+	now := time.Now()
+	if link := im.DB.db[id]; link.Disabled || link.expired(now) || !link.active(now) {
+		return -1, false, nil
+	}
+
+	return id, true, nil
+}
+
+func (im InMemoryURLDAOImpl) findTagsAndFolders(user *interface{}) ([]string, []string, error) {
+	owner, ok := userKey(user)
+	if !ok {
+		return nil, nil, errorIncompatibleTypes()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	tags, folders := map[string]bool{}, map[string]bool{}
+
+	for id, url := range im.DB.db {
+		if im.DB.owners[id] != owner {
+			continue
+		}
+
+		for _, tag := range url.Tags {
+			tags[tag] = true
+		}
+
+		if url.Folder != "" {
+			folders[url.Folder] = true
+		}
+	}
+
+	return sortedKeys(tags), sortedKeys(folders), nil
+}
+
+func (im InMemoryURLDAOImpl) bulkEdit(user *interface{}, ids []int, edit LinkEdit) (int, error) {
+	owner, ok := userKey(user)
+	if !ok {
+		return 0, errorIncompatibleTypes()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	edited := 0
+
+	for _, id := range ids {
+		url, found := im.DB.db[id]
+		if !found || im.DB.owners[id] != owner {
+			continue
+		}
+
+		im.DB.db[id] = edit.apply(url)
+		edited++
+	}
+
+	return edited, nil
+}
+
+func (im InMemoryURLDAOImpl) disable(id int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	url, ok := im.DB.db[id]
+	if !ok {
+		return errorURLNotFound(id)
+	}
+
+	url.Disabled = true
+	im.DB.db[id] = url
+
+	return nil
+}
+
 func (dao InMemoryUserDAOImpl) addUser(username, password string) (interface{}, error) {
 	hashPassword := password
 
@@ -125,6 +486,16 @@ func (dao InMemoryUserDAOImpl) findByUsername(username string) (interface{}, err
 	return user, nil
 }
 
+func (dao InMemoryUserDAOImpl) findUsernameByKey(key string) (string, error) {
+	for username, user := range dao.db {
+		if strconv.FormatUint(user.ID, 10) == key {
+			return username, nil
+		}
+	}
+
+	return "", errorUserNotFound(key)
+}
+
 func (dao InMemoryUserDAOImpl) validateUserAndPassword(username, password string) (bool, error) {
 	user, err := dao.findByUsername(username)
 	if err != nil {
@@ -154,32 +525,236 @@ func (dao InMemoryUserDAOImpl) findAll() ([]interface{}, error) {
 	return users, nil
 }
 
-func (dao StatsDAOMemoryImpl) save(shortURL string, headers *map[string][]string, user *interface{}) (int, error) {
-	urlShortID := shortURLToID(shortURL, chars)
+func (dao StatsDAOMemoryImpl) ping(_ context.Context) error {
+	return nil
+}
+
+func (dao StatsDAOMemoryImpl) save(click *ClickInfo, owner string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Clicks on links without a known owner are kept under the zero user ID.
+	ownerID, _ := strconv.ParseUint(owner, 10, 64)
+	userID := int(ownerID)
+
+	stat := StatsInMemory{
+		CreatedAt: time.Now(),
+		ShortID:   shortURLToID(click.ShortURL, chars),
+		UserID:    userID,
+		Headers:   click.Headers,
+		Bot:       click.Bot,
+		Country:   click.Location.Country,
+		Region:    click.Location.Region,
+		City:      click.Location.City,
+		Source:    click.Source,
+		Rule:      click.Rule,
+		Variant:   click.Variant,
+	}
+
+	dao.db[userID] = append(dao.db[userID], stat)
+
+	return len(dao.db[userID]), nil
+}
+
+// filterStats copies the matching clicks out while holding the lock, so fn is free to be slow.
+func (dao StatsDAOMemoryImpl) filterStats(match func(StatsInMemory) bool, fn func(Click) error) error {
+	mu.RLock()
+
+	var clicks []Click
+
+	for _, userStats := range dao.db {
+		for _, stat := range userStats {
+			if match(stat) {
+				clicks = append(clicks, stat.toClick())
+			}
+		}
+	}
+
+	mu.RUnlock()
+
+	sort.Slice(clicks, func(i, j int) bool {
+		return clicks[i].CreatedAt.Before(clicks[j].CreatedAt)
+	})
+
+	for _, click := range clicks {
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (dao StatsDAOMemoryImpl) streamByShortID(shortID int, filter StatsFilter, fn func(Click) error) error {
+	return dao.filterStats(func(stat StatsInMemory) bool {
+		return stat.ShortID == shortID && filter.matches(stat.CreatedAt, stat.Bot)
+	}, fn)
+}
 
+func (dao StatsDAOMemoryImpl) streamByUser(user *interface{}, filter StatsFilter, fn func(Click) error) error {
 	u, ok := (*user).(*UserInMemory)
 	if !ok {
-		return -1, errorIncompatibleTypes()
+		return errorIncompatibleTypes()
 	}
 
-	statsHeaderByShortID := dao.db[int(u.ID)]
-	stat := StatsInMemory{
-		CreatedAt: time.Now(),
-		ShortID: urlShortID,
-		UserID: int(u.ID),
-		Headers: *headers,
+	return dao.filterStats(func(stat StatsInMemory) bool {
+		return stat.UserID == int(u.ID) && filter.matches(stat.CreatedAt, stat.Bot)
+	}, fn)
+}
+
+func (dao StatsDAOMemoryImpl) findByShortID(shortID int, filter StatsFilter) ([]Click, error) {
+	return collectClicks(func(fn func(Click) error) error {
+		return dao.streamByShortID(shortID, filter, fn)
+	})
+}
+
+func (dao StatsDAOMemoryImpl) findAllByUser(user *interface{}, filter StatsFilter) ([]Click, error) {
+	return collectClicks(func(fn func(Click) error) error {
+		return dao.streamByUser(user, filter, fn)
+	})
+}
+
+func (dao StatsDAOMemoryImpl) countByShortIDs(ids []int, filter StatsFilter) (map[int]ClickCount, error) {
+	counts := make(map[int]ClickCount, len(ids))
+
+	for _, id := range ids {
+		counts[id] = ClickCount{}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, userStats := range dao.db {
+		for _, stat := range userStats {
+			count, ok := counts[stat.ShortID]
+			if !ok || !filter.matches(stat.CreatedAt, stat.Bot) {
+				continue
+			}
+
+			count.add(stat.Variant, 1)
+			counts[stat.ShortID] = count
+		}
 	}
 
-	statsHeaderByShortID = append(statsHeaderByShortID, stat)
+	return counts, nil
+}
 
-	return 0, nil
+func (s StatsInMemory) toClick() Click {
+	headers := http.Header(s.Headers)
+
+	return Click{
+		CreatedAt: s.CreatedAt,
+		ShortURL:  idToShortURL(s.ShortID, chars),
+		Bot:       s.Bot,
+		Country:   s.Country,
+		Region:    s.Region,
+		City:      s.City,
+		Referrer:  headers.Get("Referer"),
+		UserAgent: headers.Get("User-Agent"),
+		Source:    s.Source,
+		Rule:      s.Rule,
+		Variant:   s.Variant,
+	}
 }
 
-// TODO: missing impl
-func (dao StatsDAOMemoryImpl) findByShortID(shortID int) ([]interface{}, error) {
-	return []interface{}{}, nil
+func (dao WebhookMemoryImpl) save(hook Webhook) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	dao.db.hooks[hook.ID] = hook
+
+	return nil
 }
 
-func (dao StatsDAOMemoryImpl) findAllByUser(user *interface{}) ([]interface{}, error) {
-	return []interface{}{}, nil
-}
\ No newline at end of file
+func (dao WebhookMemoryImpl) delete(userKey, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	hook, ok := dao.db.hooks[id]
+	if !ok || hook.UserKey != userKey {
+		return errorWebhookNotFound(id)
+	}
+
+	delete(dao.db.hooks, id)
+	delete(dao.db.deliveries, id)
+
+	return nil
+}
+
+func (dao WebhookMemoryImpl) findByID(userKey, id string) (Webhook, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	hook, ok := dao.db.hooks[id]
+	if !ok || hook.UserKey != userKey {
+		return Webhook{}, errorWebhookNotFound(id)
+	}
+
+	return hook, nil
+}
+
+func (dao WebhookMemoryImpl) findAllByUser(userKey string) ([]Webhook, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	hooks := []Webhook{}
+
+	for _, hook := range dao.db.hooks {
+		if hook.UserKey == userKey {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+
+	return hooks, nil
+}
+
+func (dao WebhookMemoryImpl) saveDelivery(delivery WebhookDelivery) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := dao.db.hooks[delivery.WebhookID]; !ok {
+		return errorWebhookNotFound(delivery.WebhookID)
+	}
+
+	dao.db.deliveries[delivery.WebhookID] = append(dao.db.deliveries[delivery.WebhookID], delivery)
+
+	return nil
+}
+
+func (dao WebhookMemoryImpl) findDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	deliveries := []WebhookDelivery{}
+	all := dao.db.deliveries[webhookID]
+
+	// Newest first.
+	for i := len(all) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		deliveries = append(deliveries, all[i])
+	}
+
+	return deliveries, nil
+}
+
+func (dao BlocklistMemoryImpl) save(entry BlocklistEntry) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	dao.db.entries = append(dao.db.entries, entry)
+
+	return nil
+}
+
+func (dao BlocklistMemoryImpl) findAll() ([]BlocklistEntry, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	entries := make([]BlocklistEntry, len(dao.db.entries))
+	copy(entries, dao.db.entries)
+
+	return entries, nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxFolderLength = 100
+
+	// maxTagSuggestions is the number of tags returned by the autocomplete.
+	maxTagSuggestions = 20
+
+	linkSortCreated   = "created"
+	linkSortClicks    = "clicks"
+	linkSortLastClick = "last_click"
+
+	defaultLinksPerPage = 50
+	maxLinksPerPage     = 200
+)
+
+var linkSorts = map[string]bool{
+	linkSortCreated:   true,
+	linkSortClicks:    true,
+	linkSortLastClick: true,
+}
+
+// cursorTime turns a LinkCursor value back into a time, 0 is the zero time links that were
+// never clicked have.
+func cursorTime(value int64) time.Time {
+	if value == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, value).UTC()
+}
+
+func timeCursorValue(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+// sortValue returns the sort key of a link for q.Sort.
+func (q LinkQuery) sortValue(u URLStat) int64 {
+	switch q.Sort {
+	case linkSortClicks:
+		return int64(u.Clicks)
+	case linkSortLastClick:
+		if u.LastClickAt == nil {
+			return 0
+		}
+
+		return timeCursorValue(*u.LastClickAt)
+	default:
+		return timeCursorValue(u.CreatedAt)
+	}
+}
+
+// cursorParam is the value of q.After as the engines store it: a time or a click count.
+func (q LinkQuery) cursorParam() interface{} {
+	if q.Sort == linkSortClicks {
+		return q.After.Value
+	}
+
+	return cursorTime(q.After.Value)
+}
+
+// before tells whether a comes strictly before b in the order of q, a link is never before
+// itself so the one a cursor points at is not repeated on the next page.
+func (q LinkQuery) before(a, b LinkCursor) bool {
+	if a.Value != b.Value {
+		return (a.Value < b.Value) == q.Asc
+	}
+
+	if a.ShortID == b.ShortID {
+		return false
+	}
+
+	return (a.ShortID < b.ShortID) == q.Asc
+}
+
+// searchShortID returns the ID of the short code search stands for, or -1 when it is not one.
+func searchShortID(search string) int {
+	if search == "" || len(search) > maxAliasLength {
+		return -1
+	}
+
+	for _, r := range search {
+		if !strings.ContainsRune(string(chars), r) {
+			return -1
+		}
+	}
+
+	return shortURLToID(search, chars)
+}
+
+// matchesSearch is the engine independent version of the LinkQuery search.
+func (q LinkQuery) matchesSearch(shortID int, url URL) bool {
+	if q.Search == "" {
+		return true
+	}
+
+	search := strings.ToLower(q.Search)
+
+	return shortID == searchShortID(q.Search) ||
+		strings.Contains(strings.ToLower(url.URL), search) ||
+		strings.Contains(strings.ToLower(url.Title), search)
+}
+
+func encodeLinkCursor(cursor LinkCursor) string {
+	data, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeLinkCursor(value string) (*LinkCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errorInvalidCursor(value)
+	}
+
+	var cursor LinkCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errorInvalidCursor(value)
+	}
+
+	return &cursor, nil
+}
+
+// linkQuery builds a LinkQuery out of the tag, folder, q, sort, order, cursor and limit query
+// parameters. Links are sorted by creation date, newest first, by default.
+func linkQuery(c *gin.Context) (LinkQuery, error) {
+	q := LinkQuery{
+		LinkFilter: linkFilter(c),
+		Search:     strings.TrimSpace(c.Query("q")),
+		Sort:       c.DefaultQuery("sort", linkSortCreated),
+		Asc:        c.Query("order") == "asc",
+		Limit:      defaultLinksPerPage,
+	}
+
+	if !linkSorts[q.Sort] {
+		return q, errorInvalidLinkQuery("sort", q.Sort)
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 || value > maxLinksPerPage {
+			return q, errorInvalidLinkQuery("limit", limit)
+		}
+
+		q.Limit = value
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := decodeLinkCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+
+		q.After = after
+	}
+
+	return q, nil
+}
+
+// queryLinks returns a page of links of user and the cursor of the next one, if there is one.
+func queryLinks(user *interface{}, q LinkQuery) (LinkPage, error) {
+	limit := q.Limit
+	q.Limit++
+
+	urls, err := (*urlDAO).query(user, q)
+	if err != nil {
+		return LinkPage{}, err
+	}
+
+	page := LinkPage{}
+
+	if len(urls) > limit {
+		urls = urls[:limit]
+		last := urls[limit-1]
+		page.NextCursor = encodeLinkCursor(LinkCursor{Value: q.sortValue(last), ShortID: last.ShortID})
+	}
+
+	page.Links = urlsToFullStat(&urls)
+
+	return page, nil
+}
+
+func (f LinkFilter) matches(url URL) bool {
+	if f.Folder != "" && url.Folder != f.Folder {
+		return false
+	}
+
+	if f.Tag == "" {
+		return true
+	}
+
+	for _, tag := range url.Tags {
+		if tag == f.Tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply returns url with the tags added and removed and, if any, the new folder.
+func (e LinkEdit) apply(url URL) URL {
+	remove := map[string]bool{}
+	for _, tag := range normalizeTags(e.RemoveTags) {
+		remove[tag] = true
+	}
+
+	var tags []string
+
+	for _, tag := range normalizeTags(append(append([]string{}, url.Tags...), e.AddTags...)) {
+		if !remove[tag] {
+			tags = append(tags, tag)
+		}
+	}
+
+	url.Tags = tags
+
+	if e.Folder != nil {
+		url.Folder = *e.Folder
+	}
+
+	return url
+}
+
+func normalizeFolder(folder string) (string, error) {
+	folder = strings.TrimSpace(folder)
+	if len(folder) > maxFolderLength {
+		return "", errorInvalidFolder("the name is too long")
+	}
+
+	return folder, nil
+}
+
+// linkFilter builds a LinkFilter out of the tag and folder query parameters.
+func linkFilter(c *gin.Context) LinkFilter {
+	return LinkFilter{
+		Tag:    strings.TrimSpace(c.Query("tag")),
+		Folder: strings.TrimSpace(c.Query("folder")),
+	}
+}
+
+// viewTags backs the tag autocomplete, it returns the tags of the user starting with q.
+func viewTags(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	tags, folders, err := (*urlDAO).findTagsAndFolders(&userFound)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	prefix := strings.ToLower(c.Query("q"))
+	suggestions := []string{}
+
+	for _, tag := range tags {
+		if strings.HasPrefix(strings.ToLower(tag), prefix) && len(suggestions) < maxTagSuggestions {
+			suggestions = append(suggestions, tag)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags":    suggestions,
+		"folders": folders,
+	})
+}
+
+// editLinks adds and removes tags, and optionally moves to a folder, several links at once. Tags
+// are given either as arrays (JSON) or comma separated (form). The stats page sends set_folder
+// along with the folder when it should be changed.
+func editLinks(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	type linkEditRequest struct {
+		Links      []string `form:"links" json:"links"`
+		AddTags    []string `form:"add_tags" json:"add_tags"`
+		RemoveTags []string `form:"remove_tags" json:"remove_tags"`
+		Folder     *string  `form:"folder" json:"folder"`
+	}
+
+	var request linkEditRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	if c.ContentType() != "application/json" && c.PostForm("set_folder") == "" {
+		request.Folder = nil
+	}
+
+	edit := LinkEdit{
+		AddTags:    splitTags(strings.Join(request.AddTags, ",")),
+		RemoveTags: splitTags(strings.Join(request.RemoveTags, ",")),
+	}
+
+	if request.Folder != nil {
+		folder, err := normalizeFolder(*request.Folder)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+			return
+		}
+
+		edit.Folder = &folder
+	}
+
+	ids := make([]int, 0, len(request.Links))
+	for _, shortURL := range request.Links {
+		ids = append(ids, shortURLToID(shortURL, chars))
+	}
+
+	edited, err := (*urlDAO).bulkEdit(&userFound, ids, edit)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	if !wantsJSON(c) && c.ContentType() != "application/json" {
+		c.Redirect(http.StatusSeeOther, "/stats")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"edited": edited})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLinkQuery(t *testing.T) {
+	cursor := encodeLinkCursor(LinkCursor{Value: 42, ShortID: 7})
+
+	tests := []struct {
+		query string
+		want  LinkQuery
+		err   bool
+	}{
+		{
+			query: "",
+			want:  LinkQuery{Sort: linkSortCreated, Limit: defaultLinksPerPage},
+		},
+		{
+			query: "?q=+docs+&tag=work&folder=q1&sort=clicks&order=asc&limit=10&cursor=" + cursor,
+			want: LinkQuery{
+				LinkFilter: LinkFilter{Tag: "work", Folder: "q1"},
+				Search:     "docs",
+				Sort:       linkSortClicks,
+				Asc:        true,
+				Limit:      10,
+				After:      &LinkCursor{Value: 42, ShortID: 7},
+			},
+		},
+		{query: "?sort=title", err: true},
+		{query: "?limit=0", err: true},
+		{query: "?limit=abc", err: true},
+		{query: fmt.Sprintf("?limit=%d", maxLinksPerPage+1), err: true},
+		{query: "?cursor=not-base64!", err: true},
+		{query: "?cursor=bm90IGpzb24", err: true},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/api/urls"+tt.query, nil)
+
+		q, err := linkQuery(c)
+
+		if tt.err {
+			if err == nil {
+				t.Errorf("linkQuery(%q) = %+v, want an error", tt.query, q)
+			}
+
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(q, tt.want) {
+			t.Errorf("linkQuery(%q) = %+v, %v, want %+v", tt.query, q, err, tt.want)
+		}
+	}
+}
+
+func TestLinkCursorRoundTrip(t *testing.T) {
+	cursor := LinkCursor{Value: time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC).UnixNano(), ShortID: 12345}
+
+	decoded, err := decodeLinkCursor(encodeLinkCursor(cursor))
+	if err != nil || *decoded != cursor {
+		t.Fatalf("decodeLinkCursor(encodeLinkCursor(%+v)) = %+v, %v", cursor, decoded, err)
+	}
+
+	if _, err := decodeLinkCursor("%%%"); !errors.Is(err, errInvalidLinkQuery) {
+		t.Fatalf("decodeLinkCursor() error = %v, want %v", err, errInvalidLinkQuery)
+	}
+}
+
+func TestLinkQueryBefore(t *testing.T) {
+	tests := []struct {
+		asc  bool
+		a, b LinkCursor
+		want bool
+	}{
+		{asc: true, a: LinkCursor{Value: 1, ShortID: 9}, b: LinkCursor{Value: 2, ShortID: 1}, want: true},
+		{asc: false, a: LinkCursor{Value: 1, ShortID: 9}, b: LinkCursor{Value: 2, ShortID: 1}, want: false},
+		{asc: true, a: LinkCursor{Value: 2, ShortID: 1}, b: LinkCursor{Value: 2, ShortID: 3}, want: true},
+		{asc: false, a: LinkCursor{Value: 2, ShortID: 1}, b: LinkCursor{Value: 2, ShortID: 3}, want: false},
+		{asc: true, a: LinkCursor{Value: 2, ShortID: 3}, b: LinkCursor{Value: 2, ShortID: 3}, want: false},
+		{asc: false, a: LinkCursor{Value: 2, ShortID: 3}, b: LinkCursor{Value: 2, ShortID: 3}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := (LinkQuery{Asc: tt.asc}).before(tt.a, tt.b); got != tt.want {
+			t.Errorf("before(%+v, %+v) asc=%v = %v, want %v", tt.a, tt.b, tt.asc, got, tt.want)
+		}
+	}
+}
+
+func TestSearchShortID(t *testing.T) {
+	tests := []struct {
+		search string
+		want   int
+	}{
+		{search: "b", want: 1},
+		{search: "ba", want: shortURLToID("ba", chars)},
+		{search: ""},
+		{search: "not a code"},
+		{search: "zzzzzzzzzzz"},
+	}
+
+	for _, tt := range tests {
+		want := tt.want
+		if want == 0 {
+			want = -1
+		}
+
+		if got := searchShortID(tt.search); got != want {
+			t.Errorf("searchShortID(%q) = %d, want %d", tt.search, got, want)
+		}
+	}
+}
+
+// TestQueryLinksPagination walks every page of every sort order and checks each link shows up
+// once, in order, even when several links share the sort value.
+func TestQueryLinksPagination(t *testing.T) {
+	useMemoryEngine(t)
+
+	user := addTestUser(t, "pager")
+	other := addTestUser(t, "other")
+
+	clicks := []int{3, 0, 5, 3, 1, 0, 3}
+	ids := make([]int, len(clicks))
+
+	for i, n := range clicks {
+		id, err := (*urlDAO).save(URL{URL: fmt.Sprintf("https://example.com/%d", i)}, &user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < n; j++ {
+			if err := (*urlDAO).recordClick(id, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ids[i] = id
+	}
+
+	if _, err := (*urlDAO).save(URL{URL: "https://example.com/other"}, &other); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sort := range []string{linkSortCreated, linkSortClicks, linkSortLastClick} {
+		for _, asc := range []bool{true, false} {
+			q := LinkQuery{Sort: sort, Asc: asc, Limit: 3}
+
+			var (
+				seen    = map[string]bool{}
+				pages   int
+				last    *LinkCursor
+				lastURL string
+			)
+
+			for {
+				page, err := queryLinks(&user, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				pages++
+
+				for _, link := range page.Links {
+					if seen[link.ShortURL] {
+						t.Fatalf("%s asc=%v: %s returned twice", sort, asc, link.ShortURL)
+					}
+
+					seen[link.ShortURL] = true
+
+					url, err := (*urlDAO).findByID(shortURLToID(link.ShortURL, chars))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					stat := URLStat{
+						ShortID: shortURLToID(link.ShortURL, chars), CreatedAt: url.CreatedAt,
+						Clicks: url.Clicks, LastClickAt: url.LastClickAt,
+					}
+					cursor := LinkCursor{Value: q.sortValue(stat), ShortID: stat.ShortID}
+
+					if last != nil && !q.before(*last, cursor) {
+						t.Fatalf("%s asc=%v: %s comes after %s", sort, asc, link.ShortURL, lastURL)
+					}
+
+					last, lastURL = &cursor, link.ShortURL
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+
+				if q.After, err = decodeLinkCursor(page.NextCursor); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if len(seen) != len(ids) || pages != 3 {
+				t.Fatalf("%s asc=%v: %d links in %d pages, want %d in 3", sort, asc, len(seen), pages, len(ids))
+			}
+		}
+	}
+}
+
+func TestQueryLinksSearch(t *testing.T) {
+	useMemoryEngine(t)
+
+	user := addTestUser(t, "searcher")
+
+	for _, destination := range []string{"https://docs.example.com/", "https://example.com/Docs", "https://example.org/"} {
+		if _, err := (*urlDAO).save(URL{URL: destination}, &user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		search string
+		want   int
+	}{
+		{search: "docs", want: 2},
+		{search: "EXAMPLE.ORG", want: 1},
+		{search: "b", want: 1},
+		{search: "nothing", want: 0},
+	}
+
+	for _, tt := range tests {
+		page, err := queryLinks(&user, LinkQuery{Search: tt.search, Sort: linkSortCreated, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Links) != tt.want {
+			t.Errorf("search %q returned %d links, want %d", tt.search, len(page.Links), tt.want)
+		}
+	}
+}
@@ -3,17 +3,18 @@ package main
 import (
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/gin-contrib/sessions"
-	redisSession "github.com/gin-contrib/sessions/redis"
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis"
 )
 
 const (
@@ -21,12 +22,27 @@ const (
 	MaxIdleConnections = 10
 )
 
-func init() {
+// setup reads the configuration and connects the storage, it runs from main so that the tests
+// do not need any of it.
+func setup() {
 	var err error
 
 	envConfig, err = readConfig("config.env", ".", map[string]interface{}{
-		"dbengine": "memory",
-		"port":     "8080",
+		"dbengine":           "memory",
+		"port":               "8080",
+		"ALLOWED_SCHEMES":    "http,https",
+		"MAX_URL_LENGTH":     DefaultMaxURLLength,
+		"TRAILING_SLASH":     "keep",
+		"BLOCKLIST_REFRESH":  DefaultBlocklistRefresh,
+		"METADATA_TIMEOUT":   DefaultMetadataTimeout,
+		"METADATA_MAX_SIZE":  DefaultMetadataMaxSize,
+		"QUERY_PASSTHROUGH":  DefaultQueryPolicy,
+		"CACHE_SIZE":         DefaultCacheSize,
+		"CACHE_TTL":          DefaultCacheTTL,
+		"CACHE_NEGATIVE_TTL": DefaultCacheNegativeTTL,
+		"SHUTDOWN_TIMEOUT":   DefaultShutdownTimeout,
+		"SESSION_STORE":      DefaultSessionStore,
+		"SESSION_MAX_AGE":    DefaultSessionMaxAge,
 	})
 
 	if err != nil {
@@ -35,18 +51,13 @@ func init() {
 	}
 
 	serverPort = envConfig.GetString("port")
+	urlPolicy = newURLPolicy(envConfig)
+	littleuDomains = ownDomains(envConfig)
+	queryPolicy = defaultQueryPolicy(envConfig)
+	notYetAvailableURL = envConfig.GetString("NOT_YET_AVAILABLE_URL")
 
-	// Initializing redis
-	dsn := envConfig.GetString("REDIS_DSN")
-	if len(dsn) == 0 {
-		dsn = "localhost:6379"
-	}
-
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: dsn,
-	})
-
-	_, err = redisClient.Ping().Result()
+	// Redis and Mongo are only connected to when the configuration needs them.
+	redisClient, err = connectRedis(envConfig)
 	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
@@ -54,22 +65,66 @@ func init() {
 
 	ctx = context.TODO()
 
-	mongoClientOptions := options.Client().ApplyURI(envConfig.GetString("MONGO_URI"))
+	mongoClient, err := connectMongo(envConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	mongoClient, err := mongo.Connect(ctx, mongoClientOptions)
+	postgresDB, err := connectPostgres(envConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = mongoClient.Ping(ctx, nil)
+	if envConfig.GetString("dbengine") == "postgresql" {
+		if err := migratePostgres(postgresDB); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	sessionStore, err = newSessionStore(envConfig, mongoClient, postgresDB)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// Initialize DB:
-	urlDAO = factoryURLDao(mongoClient, envConfig)
-	userDAO = factoryUserDAO(mongoClient, envConfig)
-	statsDAO = factoryStatsDao(mongoClient, envConfig)
+	urlDAO = cachedURLDao(factoryURLDao(mongoClient, postgresDB, envConfig), redisClient, envConfig)
+	userDAO = factoryUserDAO(mongoClient, postgresDB, envConfig)
+	statsDAO = factoryStatsDao(mongoClient, postgresDB, envConfig)
+	webhookDAO = factoryWebhookDAO(mongoClient, postgresDB, envConfig)
+	blocklistDAO = factoryBlocklistDAO(mongoClient, postgresDB, envConfig)
+
+	botDetector, err = newBotDetector(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := reloadBotSignatures(botDetector, envConfig.GetString("BOT_SIGNATURES_FILE")); err != nil {
+		log.Fatal(err)
+	}
+
+	watchBotSignatures(botDetector, envConfig.GetString("BOT_SIGNATURES_FILE"))
+
+	geoIP, err = newGeoIPResolver(
+		envConfig.GetString("GEOIP_DATABASE"),
+		strings.Split(envConfig.GetString("TRUSTED_PROXIES"), ","),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registerCloser("geoip", geoIP.close)
+
+	blocklist = newBlocklist(envConfig)
+	if err := blocklist.reload(); err != nil {
+		log.Fatal(err)
+	}
+
+	blocklist.watch(envConfig.GetDuration("BLOCKLIST_REFRESH"))
+
+	metadataFetcher = newMetadataFetcher(envConfig)
+	metadataFetcher.start()
+
+	webhookClient = newWebhookClient(parseNetworks(strings.Split(envConfig.GetString("WEBHOOK_ALLOWED_NETWORKS"), ",")))
 
 	gob.Register(&UserMongo{})
 	gob.Register(&UserPostgresql{})
@@ -77,19 +132,15 @@ func init() {
 }
 
 func main() {
+	setup()
+
 	// Set Gin to production mode
 	gin.SetMode(gin.ReleaseMode)
 
 	// Set the router as the default one provided by Gin
 	router = gin.Default()
-	// Initializing redis
-	dsn := envConfig.GetString("REDIS_DSN")
-	if len(dsn) == 0 {
-		dsn = "localhost:6379"
-	}
 
-	store, _ := redisSession.NewStore(MaxIdleConnections, "tcp", dsn, "", []byte(envConfig.GetString("SESSION_SECRET")))
-	router.Use(sessions.Sessions("sid", store))
+	router.Use(sessions.Sessions("sid", sessionStore))
 
 	router.Static("/assets", "./assets")
 
@@ -100,8 +151,26 @@ func main() {
 	// Initialize the routes
 	initializeRoutes(envConfig)
 
-	// Start serving the applications
-	if err := router.Run(net.JoinHostPort("", serverPort)); err != nil {
-		log.Fatal(err)
+	server := &http.Server{
+		Addr:    net.JoinHostPort("", serverPort),
+		Handler: router,
 	}
+
+	server.RegisterOnShutdown(func() {
+		close(shuttingDown)
+	})
+
+	// Start serving the applications
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Printf("shutting down on %v", <-stop)
+
+	shutdown(server, envConfig.GetDuration("SHUTDOWN_TIMEOUT"))
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// useMemoryEngine points the package globals at fresh memory DAOs and the default policies, the
+// tests never touch Redis, Mongo or Postgres.
+func useMemoryEngine(t *testing.T) *viper.Viper {
+	t.Helper()
+
+	config := viper.New()
+	config.Set("dbengine", "memory")
+	config.Set("ALLOWED_SCHEMES", "http,https")
+	config.Set("MAX_URL_LENGTH", DefaultMaxURLLength)
+	config.Set("TRAILING_SLASH", trailingSlashKeep)
+	config.Set("QUERY_PASSTHROUGH", DefaultQueryPolicy)
+	config.Set("METADATA_TIMEOUT", DefaultMetadataTimeout)
+	config.Set("METADATA_MAX_SIZE", DefaultMetadataMaxSize)
+
+	urlDAO = factoryURLDao(nil, nil, config)
+	userDAO = factoryUserDAO(nil, nil, config)
+	statsDAO = factoryStatsDao(nil, nil, config)
+	webhookDAO = factoryWebhookDAO(nil, nil, config)
+	blocklistDAO = factoryBlocklistDAO(nil, nil, config)
+
+	urlPolicy = newURLPolicy(config)
+	littleuDomains = ownDomains(config)
+	queryPolicy = defaultQueryPolicy(config)
+	redisClient = nil
+
+	blocklist = newBlocklist(config)
+	if err := blocklist.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+// addTestUser registers a user in the memory engine and returns it the way sessions hold it, as
+// a pointer.
+func addTestUser(t *testing.T, username string) interface{} {
+	t.Helper()
+
+	if _, err := (*userDAO).addUser(username, hashAndSalt([]byte("secret123"))); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := (*userDAO).findByUsername(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := user.(UserInMemory)
+
+	return &u
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+const (
+	// DefaultMetadataTimeout is how long fetching the metadata of a destination can take.
+	DefaultMetadataTimeout = 5 * time.Second
+
+	// DefaultMetadataMaxSize is how much of the destination page is read, the metadata is in the
+	// <head> so the start of the page is enough.
+	DefaultMetadataMaxSize = 512 << 10
+
+	metadataQueueSize    = 1000
+	metadataWorkers      = 4
+	metadataMaxRedirects = 5
+
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+// internalNetworks are the loopback, private, link-local and otherwise non public networks
+// destinations are not fetched from unless allowed by METADATA_ALLOWED_NETWORKS.
+var internalNetworks = parseNetworks([]string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+})
+
+func parseNetworks(cidrs []string) []*net.IPNet {
+	networks := []*net.IPNet{}
+
+	for _, cidr := range cidrs {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("invalid network %q: %v", cidr, err)
+
+			continue
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type metadataJob struct {
+	id          int
+	destination string
+}
+
+// MetadataFetcher fetches the title, description, favicon and Open Graph image of new links in
+// the background and stores them with URLDao.saveMetadata.
+type MetadataFetcher struct {
+	client  *http.Client
+	maxSize int64
+	// allowedNetworks are internal networks that can still be fetched from.
+	allowedNetworks []*net.IPNet
+	queue           chan metadataJob
+
+	// mu guards stopped, enqueue must not send on the closed queue.
+	mu      sync.RWMutex
+	stopped bool
+	workers sync.WaitGroup
+}
+
+// newMetadataFetcher reads METADATA_TIMEOUT, METADATA_MAX_SIZE and METADATA_ALLOWED_NETWORKS.
+// Internal addresses are checked when connecting, so neither redirects nor DNS answers can
+// point the fetcher to them.
+func newMetadataFetcher(config *viper.Viper) *MetadataFetcher {
+	f := &MetadataFetcher{
+		maxSize:         config.GetInt64("METADATA_MAX_SIZE"),
+		allowedNetworks: parseNetworks(strings.Split(config.GetString("METADATA_ALLOWED_NETWORKS"), ",")),
+		queue:           make(chan metadataJob, metadataQueueSize),
+	}
+
+	if f.maxSize <= 0 {
+		f.maxSize = DefaultMetadataMaxSize
+	}
+
+	timeout := config.GetDuration("METADATA_TIMEOUT")
+	if timeout <= 0 {
+		timeout = DefaultMetadataTimeout
+	}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: checkAddress(f.allowedNetworks),
+	}
+
+	f.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= metadataMaxRedirects {
+				return http.ErrUseLastResponse
+			}
+
+			return nil
+		},
+	}
+
+	return f
+}
+
+// checkAddress returns a net.Dialer Control refusing connections to internal networks which are
+// not in allowed.
+func checkAddress(allowed []*net.IPNet) func(network, address string, c syscall.RawConn) error {
+	return func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("invalid address %q", address)
+		}
+
+		if inNetworks(ip, internalNetworks) && !inNetworks(ip, allowed) {
+			return fmt.Errorf("%s is an internal address", ip)
+		}
+
+		return nil
+	}
+}
+
+// start runs the workers fetching the queued links.
+func (f *MetadataFetcher) start() {
+	for i := 0; i < metadataWorkers; i++ {
+		f.workers.Add(1)
+
+		go func() {
+			defer f.workers.Done()
+
+			for job := range f.queue {
+				f.update(job.id, job.destination)
+			}
+		}()
+	}
+}
+
+// stop lets the workers finish the queued links until ctx is done and reports whether they did.
+// Links enqueued afterwards are dropped.
+func (f *MetadataFetcher) stop(ctx context.Context) bool {
+	f.mu.Lock()
+	if !f.stopped {
+		f.stopped = true
+		close(f.queue)
+	}
+	f.mu.Unlock()
+
+	return waitWithContext(ctx, &f.workers)
+}
+
+// enqueue schedules fetching the metadata of a link, it is dropped when the queue is full.
+func (f *MetadataFetcher) enqueue(id int, destination string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.stopped {
+		return
+	}
+
+	select {
+	case f.queue <- metadataJob{id: id, destination: destination}:
+	default:
+		log.Printf("metadata queue full, skipping %s", idToShortURL(id, chars))
+	}
+}
+
+func (f *MetadataFetcher) update(id int, destination string) {
+	metadata, err := f.fetch(context.Background(), destination)
+	if err != nil {
+		log.Printf("error fetching metadata of %s: %v", idToShortURL(id, chars), err)
+
+		return
+	}
+
+	if metadata == (LinkMetadata{}) {
+		return
+	}
+
+	if err := (*urlDAO).saveMetadata(id, metadata); err != nil {
+		log.Printf("error saving metadata of %s: %v", idToShortURL(id, chars), err)
+	}
+}
+
+// fetch downloads the start of an HTML destination and extracts its metadata, other content
+// types have none.
+func (f *MetadataFetcher) fetch(ctx context.Context, destination string) (LinkMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return LinkMetadata{}, err
+	}
+
+	req.Header.Set("User-Agent", "littleu (link preview)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return LinkMetadata{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return LinkMetadata{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return LinkMetadata{}, nil
+	}
+
+	return parseMetadata(io.LimitReader(resp.Body, f.maxSize), resp.Request.URL), nil
+}
+
+// parseMetadata reads the metadata out of the <head> of a page, Open Graph values take
+// precedence over the <title> and the description. Relative URLs are resolved against base.
+func parseMetadata(r io.Reader, base *url.URL) LinkMetadata {
+	var (
+		metadata            LinkMetadata
+		ogTitle, ogDesc     string
+		inTitle, titleFound bool
+	)
+
+	tokenizer := html.NewTokenizer(r)
+
+	for {
+		tokenType := tokenizer.Next()
+
+		switch tokenType {
+		case html.ErrorToken:
+			return metadata.finish(ogTitle, ogDesc, base)
+		case html.TextToken:
+			if inTitle && !titleFound {
+				metadata.Title += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+
+			switch string(name) {
+			case "title":
+				inTitle, titleFound = false, true
+			case "head":
+				return metadata.finish(ogTitle, ogDesc, base)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			attrs := map[string]string{}
+
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				attrs[string(key)] = string(value)
+			}
+
+			switch string(name) {
+			case "title":
+				inTitle = tokenType == html.StartTagToken
+			case "body":
+				return metadata.finish(ogTitle, ogDesc, base)
+			case "meta":
+				switch strings.ToLower(attrs["property"] + attrs["name"]) {
+				case "og:title":
+					ogTitle = attrs["content"]
+				case "og:description":
+					ogDesc = attrs["content"]
+				case "og:image":
+					metadata.Image = attrs["content"]
+				case "description":
+					metadata.Description = attrs["content"]
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					if rel == "icon" && metadata.Favicon == "" {
+						metadata.Favicon = attrs["href"]
+					}
+				}
+			}
+		}
+	}
+}
+
+func (m LinkMetadata) finish(ogTitle, ogDesc string, base *url.URL) LinkMetadata {
+	if ogTitle != "" {
+		m.Title = ogTitle
+	}
+
+	if ogDesc != "" {
+		m.Description = ogDesc
+	}
+
+	m.Title = truncate(strings.Join(strings.Fields(m.Title), " "), maxTitleLength)
+	m.Description = truncate(strings.Join(strings.Fields(m.Description), " "), maxDescriptionLength)
+	m.Favicon = absoluteURL(base, m.Favicon)
+	m.Image = absoluteURL(base, m.Image)
+
+	return m
+}
+
+// absoluteURL resolves ref against base, only http(s) URLs are kept.
+func absoluteURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.String()) > urlPolicy.MaxLength {
+		return ""
+	}
+
+	return u.String()
+}
+
+// truncate cuts s to at most limit runes.
+func truncate(s string, limit int) string {
+	if runes := []rune(s); len(runes) > limit {
+		return string(runes[:limit])
+	}
+
+	return s
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMetadata(t *testing.T) {
+	useMemoryEngine(t)
+
+	base, _ := url.Parse("https://example.com/blog/post")
+
+	tests := []struct {
+		name string
+		html string
+		want LinkMetadata
+	}{
+		{
+			name: "title and description",
+			html: `<html><head><title>  A   post
+				</title><meta name="description" content="About things"></head></html>`,
+			want: LinkMetadata{Title: "A post", Description: "About things"},
+		},
+		{
+			name: "open graph wins",
+			html: `<head><title>Page</title><meta name="description" content="Plain">
+				<meta property="og:title" content="OG title"><meta property="OG:Description" content="OG description">
+				<meta property="og:image" content="/img/cover.png"></head>`,
+			want: LinkMetadata{Title: "OG title", Description: "OG description", Image: "https://example.com/img/cover.png"},
+		},
+		{
+			name: "first icon resolved against the page",
+			html: `<head><link rel="stylesheet" href="a.css"><link rel="shortcut icon" href="icon.ico">
+				<link rel="icon" href="other.ico"></head>`,
+			want: LinkMetadata{Favicon: "https://example.com/blog/icon.ico"},
+		},
+		{
+			name: "unsafe urls are dropped",
+			html: `<head><link rel="icon" href="javascript:alert(1)"><meta property="og:image" content="data:image/png;base64,AA"></head>`,
+			want: LinkMetadata{},
+		},
+		{
+			name: "the body is not read",
+			html: `<head></head><body><title>Not the title</title></body>`,
+			want: LinkMetadata{},
+		},
+		{
+			name: "long titles are truncated",
+			html: "<title>" + strings.Repeat("é", maxTitleLength+10) + "</title>",
+			want: LinkMetadata{Title: strings.Repeat("é", maxTitleLength)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMetadata(strings.NewReader(tt.html), base); got != tt.want {
+				t.Fatalf("parseMetadata() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestPageServer serves an HTML page at /page, a redirect to it at /old, a plain text file
+// at /text and 404 everywhere else.
+func newTestPageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><title>Test page</title><link rel="icon" href="/favicon.ico"></head></html>`)
+	})
+	mux.Handle("/old", http.RedirectHandler("/page", http.StatusMovedPermanently))
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "<title>Not HTML</title>")
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestMetadataFetch(t *testing.T) {
+	config := useMemoryEngine(t)
+	server := newTestPageServer(t)
+
+	page := LinkMetadata{Title: "Test page", Favicon: server.URL + "/favicon.ico"}
+
+	tests := []struct {
+		name    string
+		allowed string
+		path    string
+		want    LinkMetadata
+		err     bool
+	}{
+		{name: "html page", allowed: "127.0.0.1,::1", path: "/page", want: page},
+		{name: "redirect", allowed: "127.0.0.0/8", path: "/old", want: page},
+		{name: "not html", allowed: "127.0.0.1", path: "/text"},
+		{name: "not found", allowed: "127.0.0.1", path: "/missing", err: true},
+		{name: "internal address", path: "/page", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Set("METADATA_ALLOWED_NETWORKS", tt.allowed)
+
+			metadata, err := newMetadataFetcher(config).fetch(context.Background(), server.URL+tt.path)
+
+			if tt.err {
+				if err == nil {
+					t.Fatalf("fetch() = %+v, want an error", metadata)
+				}
+
+				return
+			}
+
+			if err != nil || metadata != tt.want {
+				t.Fatalf("fetch() = %+v, %v, want %+v", metadata, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataFetcherQueue(t *testing.T) {
+	config := useMemoryEngine(t)
+	config.Set("METADATA_ALLOWED_NETWORKS", "127.0.0.1")
+
+	server := newTestPageServer(t)
+	user := addTestUser(t, "metadata")
+
+	id, err := (*urlDAO).save(URL{URL: server.URL + "/page"}, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := newMetadataFetcher(config)
+	fetcher.start()
+	fetcher.enqueue(id, server.URL+"/page")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !fetcher.stop(ctx) {
+		t.Fatal("the metadata workers did not stop")
+	}
+
+	// Links enqueued after stop are dropped instead of panicking on the closed queue.
+	fetcher.enqueue(id, server.URL+"/page")
+
+	url, err := (*urlDAO).findByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url.Title != "Test page" || url.Favicon != server.URL+"/favicon.ico" {
+		t.Fatalf("saved metadata = %+v", url.LinkMetadata)
+	}
+}
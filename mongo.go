@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,6 +15,15 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// countersCollection keeps the counters of generated IDs, urlCounterID is the one of links.
+	countersCollection = "counters"
+	urlCounterID       = "urls"
+
+	// duplicateKey is the error code of writes breaking a unique index.
+	duplicateKey = 11000
+)
+
 // MongoDBURLDAOImpl ...
 type MongoDBURLDAOImpl struct {
 	collection *mongo.Collection
@@ -31,6 +42,19 @@ type StatsMongoImpl struct {
 	ctx        context.Context
 }
 
+// WebhookMongoImpl ...
+type WebhookMongoImpl struct {
+	hooks      *mongo.Collection
+	deliveries *mongo.Collection
+	ctx        context.Context
+}
+
+// BlocklistMongoImpl ...
+type BlocklistMongoImpl struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
 // URLExists ...
 func (dao MongoDBURLDAOImpl) URLExists(urlID int) (bool, error) {
 	filter := bson.D{
@@ -50,36 +74,128 @@ func (dao MongoDBURLDAOImpl) URLExists(urlID int) (bool, error) {
 }
 
 func (dao MongoDBURLDAOImpl) save(url URL, user *interface{}) (int, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	increment := 0
-
-	maxURLID, err := dao.getMaxShortID()
+	ids, err := dao.saveBatch([]URL{url}, user)
 	if err != nil {
 		return -1, err
 	}
 
-	increment = maxURLID
-	increment++
+	return ids[0], nil
+}
 
+// saveBatch inserts every url with a single InsertMany, aliases are checked first so a batch
+// with a taken alias is not half inserted. Generated codes come from the urlCounterID counter,
+// aliases live in the same ID space but do not move it, and the unique index on shortid rejects
+// aliases taken in between.
+func (dao MongoDBURLDAOImpl) saveBatch(urls []URL, user *interface{}) ([]int, error) {
 	u, ok := (*user).(*UserMongo)
 	if !ok {
-		return -1, errorIncompatibleTypes()
+		return nil, errorIncompatibleTypes()
 	}
 
-	urlDoc := URLDocument{
-		ShortID:   increment,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		ID:        primitive.NewObjectID(),
-		URL:       url.URL,
-		UserID:    u.ID,
+	ids := make([]int, len(urls))
+	aliases := map[int]bool{}
+
+	for i, url := range urls {
+		if url.Alias == "" {
+			continue
+		}
+
+		id := shortURLToID(url.Alias, chars)
+
+		exists, err := dao.URLExists(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists || aliases[id] {
+			return nil, errorAliasTaken(url.Alias)
+		}
+
+		ids[i] = id
+		aliases[id] = true
+	}
+
+	docs := make([]interface{}, len(urls))
+
+	for i, url := range urls {
+		if url.Alias == "" {
+			id, err := dao.nextGeneratedID(aliases)
+			if err != nil {
+				return nil, err
+			}
+
+			ids[i] = id
+		}
+
+		docs[i] = URLDocument{
+			ShortID:   ids[i],
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+			ID:        primitive.NewObjectID(),
+			URL:       url.URL,
+			UserID:    u.ID,
+			Tags:      url.Tags,
+			Folder:    url.Folder,
+			ExpiresAt: url.ExpiresAt,
+
+			ActiveFrom: url.ActiveFrom,
+
+			UTM:         url.UTM,
+			QueryPolicy: url.QueryPolicy,
+		}
+	}
+
+	_, err := dao.collection.InsertMany(dao.ctx, docs)
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, writeErr := range bulkErr.WriteErrors {
+				if writeErr.Code == duplicateKey && writeErr.Index < len(urls) && urls[writeErr.Index].Alias != "" {
+					return nil, errorAliasTaken(urls[writeErr.Index].Alias)
+				}
+			}
+		}
+
+		return nil, fmt.Errorf("error inserting url: %w", err)
 	}
 
-	_, err = dao.collection.InsertOne(dao.ctx, urlDoc)
+	return ids, nil
+}
+
+// nextGeneratedID increments the urlCounterID counter until it reaches an ID not taken by an
+// alias, of the batch or of an existing link.
+func (dao MongoDBURLDAOImpl) nextGeneratedID(aliases map[int]bool) (int, error) {
+	counters := dao.collection.Database().Collection(countersCollection)
+	findOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	for {
+		var counter struct {
+			Seq int `bson:"seq"`
+		}
+
+		err := counters.FindOneAndUpdate(
+			dao.ctx,
+			bson.D{{Key: "_id", Value: urlCounterID}},
+			bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: 1}}}},
+			findOptions,
+		).Decode(&counter)
+		if err != nil {
+			return -1, fmt.Errorf("error generating url id: %w", err)
+		}
+
+		if aliases[counter.Seq] {
+			continue
+		}
+
+		exists, err := dao.URLExists(counter.Seq)
+		if err != nil {
+			return -1, err
+		}
 
-	return increment, fmt.Errorf("error inserting url: %w", err)
+		if !exists {
+			return counter.Seq, nil
+		}
+	}
 }
 
 func (dao MongoDBURLDAOImpl) filterURLs(filter interface{}) ([]URLDocument, error) {
@@ -116,6 +232,10 @@ func (dao MongoDBURLDAOImpl) filterURLs(filter interface{}) ([]URLDocument, erro
 	return urls, nil
 }
 
+func (dao MongoDBURLDAOImpl) ping(ctx context.Context) error {
+	return dao.collection.Database().Client().Ping(ctx, nil)
+}
+
 func (dao MongoDBURLDAOImpl) findByID(id int) (URL, error) {
 	filter := bson.D{
 		primitive.E{Key: "shortid", Value: id},
@@ -126,12 +246,30 @@ func (dao MongoDBURLDAOImpl) findByID(id int) (URL, error) {
 	err := dao.collection.FindOne(dao.ctx, filter).Decode(&urlDoc)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return URL{}, fmt.Errorf("error, no documents: %v", err)
+			return URL{}, errorURLNotFound(id)
 		}
+
+		return URL{}, fmt.Errorf("error getting url: %w", err)
 	}
 
 	url := URL{}
 	url.URL = urlDoc.URL
+	url.Tags = urlDoc.Tags
+	url.Folder = urlDoc.Folder
+	url.CreatedAt = urlDoc.CreatedAt
+	url.Clicks = urlDoc.Clicks
+	url.LastClickAt = urlDoc.toURLStat().LastClickAt
+	url.ExpiresAt = urlDoc.ExpiresAt
+	url.Disabled = urlDoc.Disabled
+	url.LinkMetadata = urlDoc.LinkMetadata
+	url.Rules = urlDoc.Rules
+	url.Variants = urlDoc.Variants
+	url.StickyVariants = urlDoc.StickyVariants
+	url.ActiveFrom = urlDoc.ActiveFrom
+	url.Schedule = urlDoc.Schedule
+	url.UTM = urlDoc.UTM
+	url.QueryPolicy = urlDoc.QueryPolicy
+	url.Owner = urlDoc.UserID.Hex()
 
 	return url, nil
 }
@@ -193,7 +331,7 @@ func (dao MongoDBURLDAOImpl) update(id int, oldURL, newURL URL) (int, error) {
 	return newID, nil
 }
 
-func (dao MongoDBURLDAOImpl) findAllByUser(user *interface{}) ([]URLStat, error) {
+func (dao MongoDBURLDAOImpl) findAllByUser(user *interface{}, linkFilter LinkFilter) ([]URLStat, error) {
 	userDB, ok := (*user).(*UserMongo)
 	if !ok {
 		return []URLStat{}, errorIncompatibleTypes()
@@ -203,6 +341,14 @@ func (dao MongoDBURLDAOImpl) findAllByUser(user *interface{}) ([]URLStat, error)
 		primitive.E{Key: "user_id", Value: userDB.ID},
 	}
 
+	if linkFilter.Tag != "" {
+		filter = append(filter, primitive.E{Key: "tags", Value: linkFilter.Tag})
+	}
+
+	if linkFilter.Folder != "" {
+		filter = append(filter, primitive.E{Key: "folder", Value: linkFilter.Folder})
+	}
+
 	allURLs, err := dao.filterURLs(filter)
 
 	if err != nil {
@@ -212,14 +358,455 @@ func (dao MongoDBURLDAOImpl) findAllByUser(user *interface{}) ([]URLStat, error)
 	return toURLStat(&allURLs), nil
 }
 
+var mongoLinkSortFields = map[string]string{
+	linkSortCreated:   "created_at",
+	linkSortClicks:    "clicks",
+	linkSortLastClick: "last_click_at",
+}
+
+func (dao MongoDBURLDAOImpl) query(user *interface{}, q LinkQuery) ([]URLStat, error) {
+	userDB, ok := (*user).(*UserMongo)
+	if !ok {
+		return []URLStat{}, errorIncompatibleTypes()
+	}
+
+	conditions := bson.A{
+		bson.D{{Key: "user_id", Value: userDB.ID}},
+	}
+
+	if q.Tag != "" {
+		conditions = append(conditions, bson.D{{Key: "tags", Value: q.Tag}})
+	}
+
+	if q.Folder != "" {
+		conditions = append(conditions, bson.D{{Key: "folder", Value: q.Folder}})
+	}
+
+	if q.Search != "" {
+		search := primitive.Regex{Pattern: regexp.QuoteMeta(q.Search), Options: "i"}
+
+		conditions = append(conditions, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "shortid", Value: searchShortID(q.Search)}},
+			bson.D{{Key: "url", Value: search}},
+			bson.D{{Key: "title", Value: search}},
+		}}})
+	}
+
+	field := mongoLinkSortFields[q.Sort]
+	direction, comparison := -1, "$lt"
+
+	if q.Asc {
+		direction, comparison = 1, "$gt"
+	}
+
+	if q.After != nil {
+		value := q.cursorParam()
+
+		conditions = append(conditions, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: field, Value: bson.D{{Key: comparison, Value: value}}}},
+			bson.D{
+				{Key: field, Value: value},
+				{Key: "shortid", Value: bson.D{{Key: comparison, Value: q.After.ShortID}}},
+			},
+		}}})
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: field, Value: direction}, {Key: "shortid", Value: direction}}).
+		SetLimit(int64(q.Limit))
+
+	cur, err := dao.collection.Find(dao.ctx, bson.D{{Key: "$and", Value: conditions}}, findOptions)
+	if err != nil {
+		return []URLStat{}, fmt.Errorf("error finding urls: %w", err)
+	}
+
+	var urlDocs []URLDocument
+	if err := cur.All(dao.ctx, &urlDocs); err != nil {
+		return []URLStat{}, fmt.Errorf("error converting urls: %w", err)
+	}
+
+	return toURLStat(&urlDocs), nil
+}
+
+func (dao MongoDBURLDAOImpl) recordClick(id int, at time.Time) error {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "clicks", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "last_click_at", Value: at}}},
+	}
+
+	result, err := dao.collection.UpdateOne(dao.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error recording click: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao MongoDBURLDAOImpl) saveMetadata(id int, metadata LinkMetadata) error {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "title", Value: metadata.Title},
+			{Key: "description", Value: metadata.Description},
+			{Key: "favicon", Value: metadata.Favicon},
+			{Key: "image", Value: metadata.Image},
+			{Key: "updated_at", Value: time.Now()},
+		}},
+	}
+
+	result, err := dao.collection.UpdateOne(dao.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error saving url metadata: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao MongoDBURLDAOImpl) saveRules(id int, rules []RedirectRule) error {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "rules", Value: rules},
+			{Key: "updated_at", Value: time.Now()},
+		}},
+	}
+
+	result, err := dao.collection.UpdateOne(dao.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error saving url rules: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao MongoDBURLDAOImpl) saveVariants(id int, variants []Variant, sticky bool) error {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "variants", Value: variants},
+			{Key: "sticky_variants", Value: sticky},
+			{Key: "updated_at", Value: time.Now()},
+		}},
+	}
+
+	result, err := dao.collection.UpdateOne(dao.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error saving url variants: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao MongoDBURLDAOImpl) saveSchedule(id int, activeFrom *time.Time, schedule []ScheduledDestination) error {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "active_from", Value: activeFrom},
+			{Key: "schedule", Value: schedule},
+			{Key: "updated_at", Value: time.Now()},
+		}},
+	}
+
+	result, err := dao.collection.UpdateOne(dao.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error saving url schedule: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao MongoDBURLDAOImpl) delete(id int) error {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	result, err := dao.collection.DeleteOne(dao.ctx, filter)
+	if err != nil {
+		return fmt.Errorf("error deleting url: %w", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao MongoDBURLDAOImpl) ownerOf(id int) (string, error) {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	var urlDoc URLDocument
+
+	err := dao.collection.FindOne(dao.ctx, filter).Decode(&urlDoc)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", errorURLNotFound(id)
+		}
+
+		return "", fmt.Errorf("error getting url: %w", err)
+	}
+
+	return urlDoc.UserID.Hex(), nil
+}
+
+func (dao MongoDBURLDAOImpl) findAll() ([]URLStat, error) {
+	allURLs, err := dao.filterURLs(bson.D{})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return []URLStat{}, nil
+		}
+
+		return []URLStat{}, err
+	}
+
+	return toURLStat(&allURLs), nil
+}
+
+// ensureIndexes creates the index behind findActiveByUser.
+func (dao MongoDBURLDAOImpl) ensureIndexes() error {
+	_, err := dao.collection.Indexes().CreateMany(dao.ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "url", Value: 1},
+			},
+		},
+		{
+			Keys:    bson.D{{Key: "shortid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The counter of generated codes starts after the links already there.
+	maxID, err := dao.getMaxShortID()
+	if err != nil {
+		return err
+	}
+
+	_, err = dao.collection.Database().Collection(countersCollection).UpdateOne(
+		dao.ctx,
+		bson.D{{Key: "_id", Value: urlCounterID}},
+		bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "seq", Value: maxID}}}},
+		options.Update().SetUpsert(true),
+	)
+
+	return err
+}
+
+func (dao MongoDBURLDAOImpl) findActiveByUser(user *interface{}, url string) (int, bool, error) {
+	userDB, ok := (*user).(*UserMongo)
+	if !ok {
+		return -1, false, errorIncompatibleTypes()
+	}
+
+	now := time.Now()
+
+	filter := bson.D{
+		primitive.E{Key: "user_id", Value: userDB.ID},
+		primitive.E{Key: "url", Value: url},
+		primitive.E{Key: "disabled", Value: bson.D{{Key: "$ne", Value: true}}},
+		primitive.E{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "expires_at", Value: nil}},
+				bson.D{{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}}},
+			}}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "active_from", Value: nil}},
+				bson.D{{Key: "active_from", Value: bson.D{{Key: "$lte", Value: now}}}},
+			}}},
+		}},
+	}
+
+	var urlDoc URLDocument
+
+	err := dao.collection.FindOne(dao.ctx, filter, options.FindOne().SetSort(bson.D{{Key: "shortid", Value: -1}})).
+		Decode(&urlDoc)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return -1, false, nil
+		}
+
+		return -1, false, fmt.Errorf("error getting url: %w", err)
+	}
+
+	return urlDoc.ShortID, true, nil
+}
+
+func (dao MongoDBURLDAOImpl) findTagsAndFolders(user *interface{}) ([]string, []string, error) {
+	userDB, ok := (*user).(*UserMongo)
+	if !ok {
+		return nil, nil, errorIncompatibleTypes()
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "user_id", Value: userDB.ID},
+	}
+
+	distinct := func(field string) ([]string, error) {
+		values, err := dao.collection.Distinct(dao.ctx, field, filter)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s: %w", field, err)
+		}
+
+		set := map[string]bool{}
+
+		for _, value := range values {
+			if s, ok := value.(string); ok && s != "" {
+				set[s] = true
+			}
+		}
+
+		return sortedKeys(set), nil
+	}
+
+	tags, err := distinct("tags")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	folders, err := distinct("folder")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tags, folders, nil
+}
+
+func (dao MongoDBURLDAOImpl) bulkEdit(user *interface{}, ids []int, edit LinkEdit) (int, error) {
+	userDB, ok := (*user).(*UserMongo)
+	if !ok {
+		return 0, errorIncompatibleTypes()
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "user_id", Value: userDB.ID},
+		primitive.E{Key: "shortid", Value: bson.D{{Key: "$in", Value: ids}}},
+	}
+
+	matched, err := dao.collection.CountDocuments(dao.ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("error editing urls: %w", err)
+	}
+
+	set := bson.D{{Key: "updated_at", Value: time.Now()}}
+	if edit.Folder != nil {
+		set = append(set, bson.E{Key: "folder", Value: *edit.Folder})
+	}
+
+	// A field cannot be the target of $addToSet and $pull in the same update.
+	updates := []bson.D{{{Key: "$set", Value: set}}}
+
+	if tags := normalizeTags(edit.AddTags); len(tags) > 0 {
+		updates = append(updates, bson.D{
+			{Key: "$addToSet", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$each", Value: tags}}}}},
+		})
+	}
+
+	if tags := normalizeTags(edit.RemoveTags); len(tags) > 0 {
+		updates = append(updates, bson.D{
+			{Key: "$pull", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$in", Value: tags}}}}},
+		})
+	}
+
+	for _, update := range updates {
+		if _, err := dao.collection.UpdateMany(dao.ctx, filter, update); err != nil {
+			return 0, fmt.Errorf("error editing urls: %w", err)
+		}
+	}
+
+	return int(matched), nil
+}
+
+func (dao MongoDBURLDAOImpl) disable(id int) error {
+	filter := bson.D{
+		primitive.E{Key: "shortid", Value: id},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "disabled", Value: true},
+			{Key: "updated_at", Value: time.Now()},
+		}},
+	}
+
+	result, err := dao.collection.UpdateOne(dao.ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error disabling url: %w", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (u URLDocument) toURLStat() URLStat {
+	stat := URLStat{
+		ShortID:   u.ShortID,
+		Url:       u.URL,
+		Tags:      u.Tags,
+		Folder:    u.Folder,
+		CreatedAt: u.CreatedAt,
+		Clicks:    u.Clicks,
+
+		LinkMetadata: u.LinkMetadata,
+	}
+
+	if !u.LastClickAt.IsZero() {
+		lastClickAt := u.LastClickAt
+		stat.LastClickAt = &lastClickAt
+	}
+
+	return stat
+}
+
 func toURLStat(urlDocs *[]URLDocument) []URLStat {
 	urls := []URLStat{}
 
 	for _, u := range *urlDocs {
-		urls = append(urls, URLStat{
-			ShortID: u.ShortID,
-			Url:     u.URL,
-		})
+		urls = append(urls, u.toURLStat())
 	}
 
 	return urls
@@ -314,6 +901,20 @@ func (dao MongoUserDaoImpl) findByUsername(username string) (interface{}, error)
 	return user, nil
 }
 
+func (dao MongoUserDaoImpl) findUsernameByKey(key string) (string, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return "", errorUserNotFound(key)
+	}
+
+	user, err := dao.filterUser(bson.D{primitive.E{Key: "_id", Value: id}})
+	if err != nil {
+		return "", errorUserNotFound(key)
+	}
+
+	return user.User, nil
+}
+
 func (dao MongoUserDaoImpl) validateUserAndPassword(username, password string) (bool, error) {
 	user, err := dao.findByUsername(username)
 	if err != nil {
@@ -356,14 +957,308 @@ func (dao MongoUserDaoImpl) findAll() ([]interface{}, error) {
 	return us, nil
 }
 
-func (dao StatsMongoImpl) save(URL string, headers *map[string][]string, user *interface{}) (int, error) {
-	return -1, nil
+func (dao StatsMongoImpl) ping(ctx context.Context) error {
+	return dao.collection.Database().Client().Ping(ctx, nil)
+}
+
+func (dao StatsMongoImpl) save(click *ClickInfo, owner string) (int, error) {
+	stat := StatsMongo{
+		ID:        primitive.NewObjectID(),
+		CreatedAt: time.Now(),
+		ShortID:   shortURLToID(click.ShortURL, chars),
+		Headers:   click.Headers,
+		Bot:       click.Bot,
+		Country:   click.Location.Country,
+		Region:    click.Location.Region,
+		City:      click.Location.City,
+		Source:    click.Source,
+		Rule:      click.Rule,
+		Variant:   click.Variant,
+	}
+
+	// Clicks on links without a known owner are stored with a zero user_id.
+	if userID, err := primitive.ObjectIDFromHex(owner); err == nil {
+		stat.UserID = userID
+	}
+
+	_, err := dao.collection.InsertOne(dao.ctx, stat)
+	if err != nil {
+		return -1, fmt.Errorf("error inserting stat: %w", err)
+	}
+
+	return stat.ShortID, nil
+}
+
+// withStatsFilter adds the date range and bot conditions of statsFilter to filter.
+func withStatsFilter(filter bson.D, statsFilter StatsFilter) bson.D {
+	from, to := statsFilter.bounds()
+
+	filter = append(filter, primitive.E{Key: "created_at", Value: bson.D{
+		{Key: "$gte", Value: from},
+		{Key: "$lt", Value: to},
+	}})
+
+	if !statsFilter.IncludeBots {
+		filter = append(filter, primitive.E{Key: "bot", Value: bson.D{{Key: "$ne", Value: true}}})
+	}
+
+	return filter
+}
+
+func (dao StatsMongoImpl) filterStats(filter bson.D, statsFilter StatsFilter, fn func(Click) error) error {
+	filter = withStatsFilter(filter, statsFilter)
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	cur, err := dao.collection.Find(dao.ctx, filter, findOptions)
+	if err != nil {
+		return fmt.Errorf("error finding stats: %w", err)
+	}
+
+	defer cur.Close(dao.ctx)
+
+	for cur.Next(dao.ctx) {
+		var stat StatsMongo
+
+		if err := cur.Decode(&stat); err != nil {
+			return fmt.Errorf("error converting stat: %w", err)
+		}
+
+		if err := fn(stat.toClick()); err != nil {
+			return err
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("error closing db cursor: %v", err)
+	}
+
+	return nil
+}
+
+func (dao StatsMongoImpl) streamByShortID(shortID int, filter StatsFilter, fn func(Click) error) error {
+	query := bson.D{
+		primitive.E{Key: "shortid", Value: shortID},
+	}
+
+	return dao.filterStats(query, filter, fn)
+}
+
+func (dao StatsMongoImpl) streamByUser(user *interface{}, filter StatsFilter, fn func(Click) error) error {
+	userDB, ok := (*user).(*UserMongo)
+	if !ok {
+		return errorIncompatibleTypes()
+	}
+
+	query := bson.D{
+		primitive.E{Key: "user_id", Value: userDB.ID},
+	}
+
+	return dao.filterStats(query, filter, fn)
+}
+
+func (dao StatsMongoImpl) findByShortID(shortID int, filter StatsFilter) ([]Click, error) {
+	return collectClicks(func(fn func(Click) error) error {
+		return dao.streamByShortID(shortID, filter, fn)
+	})
+}
+
+func (dao StatsMongoImpl) findAllByUser(user *interface{}, filter StatsFilter) ([]Click, error) {
+	return collectClicks(func(fn func(Click) error) error {
+		return dao.streamByUser(user, filter, fn)
+	})
+}
+
+func (dao StatsMongoImpl) countByShortIDs(ids []int, filter StatsFilter) (map[int]ClickCount, error) {
+	counts := make(map[int]ClickCount, len(ids))
+
+	for _, id := range ids {
+		counts[id] = ClickCount{}
+	}
+
+	match := withStatsFilter(bson.D{{Key: "shortid", Value: bson.D{{Key: "$in", Value: ids}}}}, filter)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "shortid", Value: "$shortid"}, {Key: "variant", Value: "$variant"}}},
+			{Key: "clicks", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	cur, err := dao.collection.Aggregate(dao.ctx, pipeline)
+	if err != nil {
+		return counts, fmt.Errorf("error counting stats: %w", err)
+	}
+
+	defer cur.Close(dao.ctx)
+
+	for cur.Next(dao.ctx) {
+		var group struct {
+			ID struct {
+				ShortID int    `bson:"shortid"`
+				Variant string `bson:"variant"`
+			} `bson:"_id"`
+			Clicks int `bson:"clicks"`
+		}
+
+		if err := cur.Decode(&group); err != nil {
+			return counts, fmt.Errorf("error converting stats count: %w", err)
+		}
+
+		count := counts[group.ID.ShortID]
+		count.add(group.ID.Variant, group.Clicks)
+		counts[group.ID.ShortID] = count
+	}
+
+	if err := cur.Err(); err != nil {
+		return counts, fmt.Errorf("error closing db cursor: %v", err)
+	}
+
+	return counts, nil
+}
+
+func (s StatsMongo) toClick() Click {
+	headers := http.Header(s.Headers)
+
+	return Click{
+		CreatedAt: s.CreatedAt,
+		ShortURL:  idToShortURL(s.ShortID, chars),
+		Bot:       s.Bot,
+		Country:   s.Country,
+		Region:    s.Region,
+		City:      s.City,
+		Referrer:  headers.Get("Referer"),
+		UserAgent: headers.Get("User-Agent"),
+		Source:    s.Source,
+		Rule:      s.Rule,
+		Variant:   s.Variant,
+	}
 }
 
-func (dao StatsMongoImpl) findByShortID(shortID int) ([]interface{}, error) {
-	return []interface{}{}, nil
+func (dao WebhookMongoImpl) save(hook Webhook) error {
+	_, err := dao.hooks.InsertOne(dao.ctx, hook)
+	if err != nil {
+		return fmt.Errorf("error inserting webhook: %w", err)
+	}
+
+	return nil
 }
 
-func (dao StatsMongoImpl) findAllByUser(user *interface{}) ([]interface{}, error) {
-	return []interface{}{}, nil
-}
\ No newline at end of file
+func (dao WebhookMongoImpl) delete(userKey, id string) error {
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "user_key", Value: userKey},
+	}
+
+	result, err := dao.hooks.DeleteOne(dao.ctx, filter)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return errorWebhookNotFound(id)
+	}
+
+	_, err = dao.deliveries.DeleteMany(dao.ctx, bson.D{primitive.E{Key: "webhook_id", Value: id}})
+	if err != nil {
+		return fmt.Errorf("error deleting webhook deliveries: %w", err)
+	}
+
+	return nil
+}
+
+func (dao WebhookMongoImpl) findByID(userKey, id string) (Webhook, error) {
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "user_key", Value: userKey},
+	}
+
+	var hook Webhook
+
+	err := dao.hooks.FindOne(dao.ctx, filter).Decode(&hook)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Webhook{}, errorWebhookNotFound(id)
+		}
+
+		return Webhook{}, fmt.Errorf("error getting webhook: %w", err)
+	}
+
+	return hook, nil
+}
+
+func (dao WebhookMongoImpl) findAllByUser(userKey string) ([]Webhook, error) {
+	filter := bson.D{
+		primitive.E{Key: "user_key", Value: userKey},
+	}
+
+	hooks := []Webhook{}
+
+	cur, err := dao.hooks.Find(dao.ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return hooks, fmt.Errorf("error finding webhooks: %w", err)
+	}
+
+	if err := cur.All(dao.ctx, &hooks); err != nil {
+		return hooks, fmt.Errorf("error converting webhooks: %w", err)
+	}
+
+	return hooks, nil
+}
+
+func (dao WebhookMongoImpl) saveDelivery(delivery WebhookDelivery) error {
+	_, err := dao.deliveries.InsertOne(dao.ctx, delivery)
+	if err != nil {
+		return fmt.Errorf("error inserting webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (dao WebhookMongoImpl) findDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	filter := bson.D{
+		primitive.E{Key: "webhook_id", Value: webhookID},
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit))
+
+	deliveries := []WebhookDelivery{}
+
+	cur, err := dao.deliveries.Find(dao.ctx, filter, findOptions)
+	if err != nil {
+		return deliveries, fmt.Errorf("error finding webhook deliveries: %w", err)
+	}
+
+	if err := cur.All(dao.ctx, &deliveries); err != nil {
+		return deliveries, fmt.Errorf("error converting webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (dao BlocklistMongoImpl) save(entry BlocklistEntry) error {
+	_, err := dao.collection.InsertOne(dao.ctx, entry)
+	if err != nil {
+		return fmt.Errorf("error inserting blocklist entry: %w", err)
+	}
+
+	return nil
+}
+
+func (dao BlocklistMongoImpl) findAll() ([]BlocklistEntry, error) {
+	entries := []BlocklistEntry{}
+
+	cur, err := dao.collection.Find(dao.ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return entries, fmt.Errorf("error finding blocklist entries: %w", err)
+	}
+
+	if err := cur.All(dao.ctx, &entries); err != nil {
+		return entries, fmt.Errorf("error converting blocklist entries: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// previewSuffix appended to a short link, /u/<code>+, shows its preview instead of redirecting.
+	previewSuffix = "+"
+	previewParam  = "preview"
+
+	// previewContextKey is set while a preview is rendered, so the visitor is not bound to the
+	// variant it shows.
+	previewContextKey = "link_preview"
+)
+
+// serveLinkPreview answers /u/<code>+ and /u/<code>?preview with a page describing where the link
+// goes, previews are not clicks. Any other /u/ request goes through untouched.
+func serveLinkPreview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		param := c.Param("url")
+		_, previewRequested := c.GetQuery(previewParam)
+
+		if !strings.HasSuffix(param, previewSuffix) && !previewRequested {
+			return
+		}
+
+		defer c.Abort()
+
+		shortURL := strings.TrimSuffix(param, previewSuffix)
+		c.Set(previewContextKey, true)
+
+		url, destination, ok := linkDestination(c, shortURL)
+		if !ok {
+			return
+		}
+
+		owner := ""
+
+		if url.Owner != "" {
+			name, err := (*userDAO).findUsernameByKey(url.Owner)
+			if err != nil {
+				log.Printf("error getting the owner of %s: %v", shortURL, err)
+			}
+
+			owner = name
+		}
+
+		c.HTML(
+			http.StatusOK,
+			"preview.html",
+			gin.H{
+				"title":        "Preview of " + shortURL,
+				"short_url":    shortURL,
+				"littleu_link": shortLinkURL(c, shortURL),
+				"destination":  destination,
+				"url":          url,
+				"owner":        owner,
+			},
+		)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPreviewStickyVariant(t *testing.T) {
+	useMemoryEngine(t)
+
+	geoIP = &GeoIPResolver{}
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.LoadHTMLGlob("templates/*")
+	router.GET("/u/:url", serveLinkPreview(), redirectShortURL)
+
+	user := addTestUser(t, "previewer")
+
+	id, err := (*urlDAO).save(URL{URL: "https://example.org/"}, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variants := []Variant{
+		{Name: "A", Destination: "https://example.org/a", Weight: 1},
+		{Name: "B", Destination: "https://example.org/b", Weight: 1},
+	}
+	if err := (*urlDAO).saveVariants(id, variants, true); err != nil {
+		t.Fatal(err)
+	}
+
+	shortURL := idToShortURL(id, chars)
+
+	tests := []struct {
+		path    string
+		status  int
+		cookies int
+	}{
+		{path: "/u/" + shortURL + previewSuffix, status: http.StatusOK},
+		{path: "/u/" + shortURL + "?" + previewParam, status: http.StatusOK},
+		{path: "/u/" + shortURL, status: http.StatusFound, cookies: 1},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if w.Code != tt.status || len(w.Result().Cookies()) != tt.cookies {
+			t.Errorf("GET %s = %d with %d cookies, want %d with %d",
+				tt.path, w.Code, len(w.Result().Cookies()), tt.status, tt.cookies)
+		}
+	}
+}
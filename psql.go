@@ -1,14 +1,117 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// uniqueViolation is the SQLSTATE of inserts breaking a unique index.
+const uniqueViolation = "23505"
+
+// statsQuery selects the clicks in the shape of a Click, the referrer and user agent are taken from
+// the stored request headers. Callers append their own conditions after the bot/date ones.
+const statsQuery = `
+	SELECT s.created_at, s.short_id, s.bot, s.country, s.region, s.city, s.source, s.rule, s.variant,
+		coalesce((SELECT h.value FROM stats_headers h WHERE h.stat_id = s.id AND h.name = 'Referer' LIMIT 1), ''),
+		coalesce((SELECT h.value FROM stats_headers h WHERE h.stat_id = s.id AND h.name = 'User-Agent' LIMIT 1), '')
+	FROM stats s
+	WHERE (s.bot = false OR $1) AND s.created_at >= $2 AND s.created_at < $3
+`
+
+// psqlMigrations brings an existing database up to the schema littleu expects. Every statement is
+// idempotent and they run in order on each start, new columns and tables are appended at the end.
+var psqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS stats (
+		id serial PRIMARY KEY,
+		created_at timestamptz NOT NULL,
+		short_id integer NOT NULL,
+		user_id integer,
+		bot boolean NOT NULL DEFAULT false
+	)`,
+	`CREATE INDEX IF NOT EXISTS stats_short_id_created_at_idx ON stats (short_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS stats_user_id_created_at_idx ON stats (user_id, created_at)`,
+	`CREATE TABLE IF NOT EXISTS stats_headers (
+		id serial PRIMARY KEY,
+		name text NOT NULL,
+		value text NOT NULL,
+		stat_id integer NOT NULL REFERENCES stats (id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS stats_headers_stat_id_idx ON stats_headers (stat_id)`,
+	`ALTER TABLE stats ADD COLUMN IF NOT EXISTS country text NOT NULL DEFAULT ''`,
+	`ALTER TABLE stats ADD COLUMN IF NOT EXISTS region text NOT NULL DEFAULT ''`,
+	`ALTER TABLE stats ADD COLUMN IF NOT EXISTS city text NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id text PRIMARY KEY,
+		user_key text NOT NULL,
+		url text NOT NULL,
+		secret text NOT NULL,
+		events text NOT NULL,
+		created_at timestamptz NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhooks_user_key_idx ON webhooks (user_key)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id text PRIMARY KEY,
+		webhook_id text NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+		event_id text NOT NULL,
+		event text NOT NULL,
+		attempt integer NOT NULL,
+		status_code integer NOT NULL,
+		error text NOT NULL DEFAULT '',
+		created_at timestamptz NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_created_at_idx ON webhook_deliveries (webhook_id, created_at)`,
+	`ALTER TABLE stats ADD COLUMN IF NOT EXISTS source text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled boolean NOT NULL DEFAULT false`,
+	`CREATE TABLE IF NOT EXISTS blocklist (
+		id text PRIMARY KEY,
+		entry text NOT NULL,
+		added_by text NOT NULL,
+		created_at timestamptz NOT NULL
+	)`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at timestamptz`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS tags text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS folder text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks integer NOT NULL DEFAULT 0`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS last_click_at timestamptz`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS title text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS favicon text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS image text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules jsonb`,
+	`ALTER TABLE stats ADD COLUMN IF NOT EXISTS rule text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS variants jsonb`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS sticky_variants boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE stats ADD COLUMN IF NOT EXISTS variant text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_source text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_medium text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_campaign text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_term text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm_content text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS query_policy text NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS active_from timestamptz`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS schedule jsonb`,
+}
+
+// migratePostgres runs psqlMigrations, it is called before the DAOs use the database.
+func migratePostgres(db *sql.DB) error {
+	for _, statement := range psqlMigrations {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("error migrating postgresql: %v", err)
+		}
+	}
+
+	return nil
+}
+
 // PostgresqlUserImpl ...
 type PostgresqlUserImpl struct {
 	db *sql.DB
@@ -24,6 +127,16 @@ type StatsPostgresqlImpl struct {
 	db *sql.DB
 }
 
+// BlocklistPostgresqlImpl ...
+type BlocklistPostgresqlImpl struct {
+	db *sql.DB
+}
+
+// WebhookPostgresqlImpl ...
+type WebhookPostgresqlImpl struct {
+	db *sql.DB
+}
+
 func (dao PostgresqlUserImpl) addUser(username, password string) (interface{}, error) {
 	hashPassword := password
 
@@ -69,6 +182,26 @@ func (dao PostgresqlUserImpl) findByUsername(username string) (interface{}, erro
 	return UserPostgresql{}, errorUserNotFound(username)
 }
 
+func (dao PostgresqlUserImpl) findUsernameByKey(key string) (string, error) {
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return "", errorUserNotFound(key)
+	}
+
+	var username string
+
+	err = dao.db.QueryRow(`SELECT username FROM users WHERE id = $1`, id).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errorUserNotFound(key)
+		}
+
+		return "", fmt.Errorf("error getting username: %v", err)
+	}
+
+	return username, nil
+}
+
 func (dao PostgresqlUserImpl) userExists(username string) (bool, error) {
 	var user UserPostgresql
 
@@ -112,49 +245,112 @@ func (dao PostgresqlURLDAOImpl) URLExists(urlID int) (bool, error) {
 	return false, nil
 }
 
-func (dao PostgresqlURLDAOImpl) getMaxShortID() (int, error) {
-	var id int
-
-	query := `SELECT coalesce(max(short_id), 0) FROM urls`
-
-	err := dao.db.QueryRow(query).Scan(&id)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 1, nil
-		}
-
-		return -1, fmt.Errorf("error getting max url id: %v", err)
-	}
-
-	return id, nil
-}
-
 func (dao PostgresqlURLDAOImpl) save(url URL, user *interface{}) (int, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	maxID, err := dao.getMaxShortID()
+	ids, err := dao.saveBatch([]URL{url}, user)
 	if err != nil {
 		return -1, err
 	}
 
-	maxID++
+	return ids[0], nil
+}
 
+// saveBatch inserts every url in a single transaction. Generated codes come from
+// urls_generated_id_seq, aliases live in the same ID space but do not move the sequence, and the
+// unique index on short_id rejects taken aliases.
+func (dao PostgresqlURLDAOImpl) saveBatch(urls []URL, user *interface{}) ([]int, error) {
 	u, ok := (*user).(*UserPostgresql)
 	if !ok {
-		return -1, errorIncompatibleTypes()
+		return nil, errorIncompatibleTypes()
+	}
+
+	ids := make([]int, len(urls))
+	aliases := map[int]bool{}
+
+	for i, url := range urls {
+		if url.Alias == "" {
+			continue
+		}
+
+		id := shortURLToID(url.Alias, chars)
+		if aliases[id] {
+			return nil, errorAliasTaken(url.Alias)
+		}
+
+		ids[i] = id
+		aliases[id] = true
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error creating url: %v", err)
 	}
 
 	createURLSQL := `
-		INSERT INTO urls (created_at, updated_at, url, short_id, user_id) values($1, $2, $3, $4, $5) RETURNING id
+		INSERT INTO urls (
+			created_at, updated_at, url, short_id, user_id, tags, folder, expires_at,
+			utm_source, utm_medium, utm_campaign, utm_term, utm_content, query_policy, active_from
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 	`
 
-	_, err = dao.db.Exec(createURLSQL, time.Now(), time.Now(), url.URL, maxID, u.ID)
-	if err != nil {
-		return -1, fmt.Errorf("error creating url: %v", err)
+	for i, url := range urls {
+		if url.Alias == "" {
+			if ids[i], err = nextGeneratedID(tx, aliases); err != nil {
+				_ = tx.Rollback()
+
+				return nil, err
+			}
+		}
+
+		_, err = tx.Exec(
+			createURLSQL,
+			time.Now(), time.Now(), url.URL, ids[i], u.ID, strings.Join(url.Tags, ","), url.Folder, url.ExpiresAt,
+			url.UTM.Source, url.UTM.Medium, url.UTM.Campaign, url.UTM.Term, url.UTM.Content, url.QueryPolicy,
+			url.ActiveFrom,
+		)
+		if err != nil {
+			_ = tx.Rollback()
+
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && url.Alias != "" {
+				return nil, errorAliasTaken(url.Alias)
+			}
+
+			return nil, fmt.Errorf("error creating url: %v", err)
+		}
 	}
 
-	return maxID, nil
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error creating url: %v", err)
+	}
+
+	return ids, nil
+}
+
+// nextGeneratedID takes the next value of urls_generated_id_seq not taken by an alias, of the
+// batch or of an existing link.
+func nextGeneratedID(tx *sql.Tx, aliases map[int]bool) (int, error) {
+	query := `
+		SELECT n.id FROM (SELECT nextval('urls_generated_id_seq') AS id) n
+		WHERE NOT EXISTS (SELECT 1 FROM urls WHERE short_id = n.id)
+	`
+
+	for {
+		var id int
+
+		err := tx.QueryRow(query).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			return -1, fmt.Errorf("error generating url id: %v", err)
+		}
+
+		if !aliases[id] {
+			return id, nil
+		}
+	}
 }
 
 func (dao PostgresqlURLDAOImpl) update(id int, oldURL, newURL URL) (int, error) {
@@ -188,17 +384,20 @@ func (dao PostgresqlURLDAOImpl) update(id int, oldURL, newURL URL) (int, error)
 	return newID, nil
 }
 
-func (dao PostgresqlURLDAOImpl) findAllByUser(user *interface{}) ([]URLStat, error) {
+func (dao PostgresqlURLDAOImpl) findAllByUser(user *interface{}, filter LinkFilter) ([]URLStat, error) {
 	userDB, ok := (*user).(*UserPostgresql)
 	if !ok {
 		return []URLStat{}, errorIncompatibleTypes()
 	}
 
-	query := `SELECT short_id, url FROM urls where user_id = $1`
+	query := `
+		SELECT short_id, url, tags, folder FROM urls
+		WHERE user_id = $1 AND ($2 = '' OR $2 = ANY(string_to_array(tags, ','))) AND ($3 = '' OR folder = $3)
+	`
 
 	urls := []URLStat{}
 
-	rows, err := dao.db.Query(query, userDB.ID)
+	rows, err := dao.db.Query(query, userDB.ID, filter.Tag, filter.Folder)
 	if err != nil {
 		return []URLStat{}, fmt.Errorf("error getting urls: %v", err)
 	}
@@ -208,15 +407,17 @@ func (dao PostgresqlURLDAOImpl) findAllByUser(user *interface{}) ([]URLStat, err
 	for rows.Next() {
 		var shortID int
 
-		var url string
+		var url, tags, folder string
 
-		if err := rows.Scan(&shortID, &url); err != nil {
+		if err := rows.Scan(&shortID, &url, &tags, &folder); err != nil {
 			return []URLStat{}, fmt.Errorf("error getting urls: %v", err)
 		}
 
 		urls = append(urls, URLStat{
 			ShortID: shortID,
 			Url:     url,
+			Tags:    splitTags(tags),
+			Folder:  folder,
 		})
 	}
 
@@ -227,11 +428,210 @@ func (dao PostgresqlURLDAOImpl) findAllByUser(user *interface{}) ([]URLStat, err
 	return urls, nil
 }
 
+// psqlLinkSortColumns are the columns behind the LinkQuery sorts, links that were never clicked
+// sort as the zero time, the same as in the other engines.
+var psqlLinkSortColumns = map[string]string{
+	linkSortCreated:   "created_at",
+	linkSortClicks:    "clicks",
+	linkSortLastClick: "coalesce(last_click_at, '0001-01-01 00:00:00+00')",
+}
+
+func (dao PostgresqlURLDAOImpl) query(user *interface{}, q LinkQuery) ([]URLStat, error) {
+	userDB, ok := (*user).(*UserPostgresql)
+	if !ok {
+		return []URLStat{}, errorIncompatibleTypes()
+	}
+
+	column := psqlLinkSortColumns[q.Sort]
+	direction, comparison := "DESC", "<"
+
+	if q.Asc {
+		direction, comparison = "ASC", ">"
+	}
+
+	query := `
+		SELECT short_id, url, tags, folder, created_at, clicks, last_click_at, title, description, favicon, image
+		FROM urls
+		WHERE user_id = $1 AND ($2 = '' OR $2 = ANY(string_to_array(tags, ','))) AND ($3 = '' OR folder = $3)
+		AND ($4 = '' OR strpos(lower(url), lower($4)) > 0 OR strpos(lower(title), lower($4)) > 0 OR short_id = $5)
+	`
+	args := []interface{}{userDB.ID, q.Tag, q.Folder, q.Search, searchShortID(q.Search)}
+
+	if q.After != nil {
+		query += ` AND (` + column + ` ` + comparison + ` $6 OR (` + column + ` = $6 AND short_id ` + comparison + ` $7))`
+		args = append(args, q.cursorParam(), q.After.ShortID)
+	}
+
+	query += ` ORDER BY ` + column + ` ` + direction + `, short_id ` + direction +
+		` LIMIT ` + strconv.Itoa(q.Limit)
+
+	urls := []URLStat{}
+
+	rows, err := dao.db.Query(query, args...)
+	if err != nil {
+		return []URLStat{}, fmt.Errorf("error getting urls: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var u URLStat
+
+		var tags string
+
+		err := rows.Scan(
+			&u.ShortID, &u.Url, &tags, &u.Folder, &u.CreatedAt, &u.Clicks, &u.LastClickAt,
+			&u.Title, &u.Description, &u.Favicon, &u.Image,
+		)
+		if err != nil {
+			return []URLStat{}, fmt.Errorf("error getting urls: %v", err)
+		}
+
+		u.Tags = splitTags(tags)
+		urls = append(urls, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []URLStat{}, fmt.Errorf("error closing cursor: %v", err)
+	}
+
+	return urls, nil
+}
+
+func (dao PostgresqlURLDAOImpl) recordClick(id int, at time.Time) error {
+	result, err := dao.db.Exec(`UPDATE urls SET clicks = clicks + 1, last_click_at = $1 WHERE short_id = $2`, at, id)
+	if err != nil {
+		return fmt.Errorf("error recording click: %v", err)
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao PostgresqlURLDAOImpl) saveMetadata(id int, metadata LinkMetadata) error {
+	updateURLSQL := `
+		UPDATE urls SET title = $1, description = $2, favicon = $3, image = $4, updated_at = $5 WHERE short_id = $6
+	`
+
+	result, err := dao.db.Exec(
+		updateURLSQL, metadata.Title, metadata.Description, metadata.Favicon, metadata.Image, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving url metadata: %v", err)
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+// jsonColumn encodes value for a jsonb column, pq sends []byte as binary so it goes as text and
+// as NULL when empty is true.
+func jsonColumn(value interface{}, empty bool) (sql.NullString, error) {
+	if empty {
+		return sql.NullString{}, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func (dao PostgresqlURLDAOImpl) saveRules(id int, rules []RedirectRule) error {
+	encoded, err := jsonColumn(rules, len(rules) == 0)
+	if err != nil {
+		return fmt.Errorf("error saving url rules: %v", err)
+	}
+
+	result, err := dao.db.Exec(
+		`UPDATE urls SET rules = $1::jsonb, updated_at = $2 WHERE short_id = $3`, encoded, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving url rules: %v", err)
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao PostgresqlURLDAOImpl) saveVariants(id int, variants []Variant, sticky bool) error {
+	encoded, err := jsonColumn(variants, len(variants) == 0)
+	if err != nil {
+		return fmt.Errorf("error saving url variants: %v", err)
+	}
+
+	result, err := dao.db.Exec(
+		`UPDATE urls SET variants = $1::jsonb, sticky_variants = $2, updated_at = $3 WHERE short_id = $4`,
+		encoded, sticky, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving url variants: %v", err)
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao PostgresqlURLDAOImpl) saveSchedule(id int, activeFrom *time.Time, schedule []ScheduledDestination) error {
+	encoded, err := jsonColumn(schedule, len(schedule) == 0)
+	if err != nil {
+		return fmt.Errorf("error saving url schedule: %v", err)
+	}
+
+	result, err := dao.db.Exec(
+		`UPDATE urls SET active_from = $1, schedule = $2::jsonb, updated_at = $3 WHERE short_id = $4`,
+		activeFrom, encoded, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving url schedule: %v", err)
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao PostgresqlURLDAOImpl) ping(ctx context.Context) error {
+	return dao.db.PingContext(ctx)
+}
+
 func (dao PostgresqlURLDAOImpl) findByID(id int) (URL, error) {
-	query := `SELECT url FROM urls WHERE short_id = $1`
+	query := `
+		SELECT url, tags, folder, expires_at, disabled, created_at, clicks, last_click_at,
+		title, description, favicon, image, rules, variants, sticky_variants,
+		utm_source, utm_medium, utm_campaign, utm_term, utm_content, query_policy, active_from, schedule, user_id
+		FROM urls WHERE short_id = $1
+	`
 	url := URL{}
 
-	err := dao.db.QueryRow(query, id).Scan(&url.URL)
+	var (
+		tags                      string
+		rules, variants, schedule []byte
+		userID                    sql.NullInt64
+	)
+
+	err := dao.db.QueryRow(query, id).Scan(
+		&url.URL, &tags, &url.Folder, &url.ExpiresAt, &url.Disabled, &url.CreatedAt, &url.Clicks, &url.LastClickAt,
+		&url.Title, &url.Description, &url.Favicon, &url.Image, &rules, &variants, &url.StickyVariants,
+		&url.UTM.Source, &url.UTM.Medium, &url.UTM.Campaign, &url.UTM.Term, &url.UTM.Content, &url.QueryPolicy,
+		&url.ActiveFrom, &schedule, &userID,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return URL{}, errorURLNotFound(id)
@@ -240,9 +640,264 @@ func (dao PostgresqlURLDAOImpl) findByID(id int) (URL, error) {
 		return URL{}, fmt.Errorf("error getting url: %v", err)
 	}
 
+	url.Tags = splitTags(tags)
+
+	if userID.Valid {
+		url.Owner = strconv.FormatInt(userID.Int64, 10)
+	}
+
+	// rules, variants and schedule are jsonb columns, NULL when the link has none.
+	if len(rules) > 0 {
+		if err := json.Unmarshal(rules, &url.Rules); err != nil {
+			return URL{}, fmt.Errorf("error reading url rules: %v", err)
+		}
+	}
+
+	if len(variants) > 0 {
+		if err := json.Unmarshal(variants, &url.Variants); err != nil {
+			return URL{}, fmt.Errorf("error reading url variants: %v", err)
+		}
+	}
+
+	if len(schedule) > 0 {
+		if err := json.Unmarshal(schedule, &url.Schedule); err != nil {
+			return URL{}, fmt.Errorf("error reading url schedule: %v", err)
+		}
+	}
+
 	return url, nil
 }
 
+func (dao PostgresqlURLDAOImpl) delete(id int) error {
+	result, err := dao.db.Exec(`DELETE FROM urls WHERE short_id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting url: %v", err)
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
+func (dao PostgresqlURLDAOImpl) ownerOf(id int) (string, error) {
+	query := `SELECT user_id FROM urls WHERE short_id = $1`
+
+	var userID int
+
+	err := dao.db.QueryRow(query, id).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errorURLNotFound(id)
+		}
+
+		return "", fmt.Errorf("error getting url: %v", err)
+	}
+
+	return strconv.Itoa(userID), nil
+}
+
+func (dao PostgresqlURLDAOImpl) findAll() ([]URLStat, error) {
+	query := `SELECT short_id, url FROM urls`
+
+	urls := []URLStat{}
+
+	rows, err := dao.db.Query(query)
+	if err != nil {
+		return []URLStat{}, fmt.Errorf("error getting urls: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var u URLStat
+
+		if err := rows.Scan(&u.ShortID, &u.Url); err != nil {
+			return []URLStat{}, fmt.Errorf("error getting urls: %v", err)
+		}
+
+		urls = append(urls, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []URLStat{}, fmt.Errorf("error closing cursor: %v", err)
+	}
+
+	return urls, nil
+}
+
+// ensureIndexes creates the index behind findActiveByUser, the unique index on short_id and the
+// sequence of generated codes. The sequence starts after the links already there.
+func (dao PostgresqlURLDAOImpl) ensureIndexes() error {
+	statements := []string{
+		`CREATE INDEX IF NOT EXISTS urls_user_id_url_idx ON urls (user_id, url)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS urls_short_id_key ON urls (short_id)`,
+		`DO $$
+		BEGIN
+			IF to_regclass('urls_generated_id_seq') IS NULL THEN
+				CREATE SEQUENCE urls_generated_id_seq;
+				PERFORM setval('urls_generated_id_seq', coalesce(max(short_id), 0) + 1, false) FROM urls;
+			END IF;
+		END
+		$$`,
+	}
+
+	for _, statement := range statements {
+		if _, err := dao.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (dao PostgresqlURLDAOImpl) findActiveByUser(user *interface{}, url string) (int, bool, error) {
+	userDB, ok := (*user).(*UserPostgresql)
+	if !ok {
+		return -1, false, errorIncompatibleTypes()
+	}
+
+	query := `
+		SELECT short_id FROM urls
+		WHERE user_id = $1 AND url = $2 AND NOT disabled AND (expires_at IS NULL OR expires_at > now())
+		AND (active_from IS NULL OR active_from <= now())
+		ORDER BY short_id DESC LIMIT 1
+	`
+
+	var shortID int
+
+	err := dao.db.QueryRow(query, userDB.ID, url).Scan(&shortID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, false, nil
+		}
+
+		return -1, false, fmt.Errorf("error getting url: %v", err)
+	}
+
+	return shortID, true, nil
+}
+
+func (dao PostgresqlURLDAOImpl) findTagsAndFolders(user *interface{}) ([]string, []string, error) {
+	userDB, ok := (*user).(*UserPostgresql)
+	if !ok {
+		return nil, nil, errorIncompatibleTypes()
+	}
+
+	column := func(query string) ([]string, error) {
+		values := []string{}
+
+		rows, err := dao.db.Query(query, userDB.ID)
+		if err != nil {
+			return values, fmt.Errorf("error getting tags: %v", err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var value string
+
+			if err := rows.Scan(&value); err != nil {
+				return values, fmt.Errorf("error getting tags: %v", err)
+			}
+
+			values = append(values, value)
+		}
+
+		return values, rows.Err()
+	}
+
+	tags, err := column(`
+		SELECT DISTINCT tag FROM urls, unnest(string_to_array(tags, ',')) AS tag
+		WHERE user_id = $1 AND tag <> '' ORDER BY tag
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	folders, err := column(`SELECT DISTINCT folder FROM urls WHERE user_id = $1 AND folder <> '' ORDER BY folder`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tags, folders, nil
+}
+
+// bulkEdit rewrites the tags of every link in a single transaction, the rows are locked so
+// concurrent edits are not lost.
+func (dao PostgresqlURLDAOImpl) bulkEdit(user *interface{}, ids []int, edit LinkEdit) (int, error) {
+	userDB, ok := (*user).(*UserPostgresql)
+	if !ok {
+		return 0, errorIncompatibleTypes()
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error editing urls: %v", err)
+	}
+
+	rows, err := tx.Query(
+		`SELECT short_id, tags FROM urls WHERE user_id = $1 AND short_id = ANY($2) FOR UPDATE`,
+		userDB.ID, pq.Array(ids),
+	)
+	if err != nil {
+		_ = tx.Rollback()
+
+		return 0, fmt.Errorf("error editing urls: %v", err)
+	}
+
+	urls := map[int]URL{}
+
+	for rows.Next() {
+		var shortID int
+
+		var tags string
+
+		if err := rows.Scan(&shortID, &tags); err != nil {
+			rows.Close()
+			_ = tx.Rollback()
+
+			return 0, fmt.Errorf("error editing urls: %v", err)
+		}
+
+		urls[shortID] = edit.apply(URL{Tags: splitTags(tags)})
+	}
+
+	rows.Close()
+
+	for shortID, url := range urls {
+		_, err := tx.Exec(
+			`UPDATE urls SET tags = $1, folder = coalesce($2, folder), updated_at = $3 WHERE short_id = $4`,
+			strings.Join(url.Tags, ","), edit.Folder, time.Now(), shortID,
+		)
+		if err != nil {
+			_ = tx.Rollback()
+
+			return 0, fmt.Errorf("error editing urls: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error editing urls: %v", err)
+	}
+
+	return len(urls), nil
+}
+
+func (dao PostgresqlURLDAOImpl) disable(id int) error {
+	result, err := dao.db.Exec(`UPDATE urls SET disabled = true, updated_at = $1 WHERE short_id = $2`, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("error disabling url: %v", err)
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return errorURLNotFound(id)
+	}
+
+	return nil
+}
+
 func (dao PostgresqlUserImpl) validateUserAndPassword(username, password string) (bool, error) {
 	user, err := dao.findByUsername(username)
 	if err != nil {
@@ -296,14 +951,353 @@ func (dao PostgresqlUserImpl) findAll() ([]interface{}, error) {
 	return us, nil
 }
 
-func (dao StatsPostgresqlImpl) save(URL string, headers *map[string][]string, user *interface{}) (int, error) {
-	return -1, nil
+func (dao StatsPostgresqlImpl) ping(ctx context.Context) error {
+	return dao.db.PingContext(ctx)
+}
+
+func (dao StatsPostgresqlImpl) save(click *ClickInfo, owner string) (int, error) {
+	// Clicks on links without a known owner are stored with a NULL user_id.
+	var userID sql.NullInt64
+
+	if id, err := strconv.Atoi(owner); err == nil {
+		userID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return -1, fmt.Errorf("error creating stat: %v", err)
+	}
+
+	createStatSQL := `
+		INSERT INTO stats (created_at, short_id, user_id, bot, country, region, city, source, rule, variant)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
+	`
+
+	var statID int
+
+	err = tx.QueryRow(
+		createStatSQL,
+		time.Now(), shortURLToID(click.ShortURL, chars), userID, click.Bot,
+		click.Location.Country, click.Location.Region, click.Location.City, click.Source, click.Rule, click.Variant,
+	).Scan(&statID)
+	if err != nil {
+		_ = tx.Rollback()
+
+		return -1, fmt.Errorf("error creating stat: %v", err)
+	}
+
+	createHeaderSQL := `INSERT INTO stats_headers (name, value, stat_id) VALUES ($1, $2, $3)`
+
+	for name, values := range click.Headers {
+		for _, value := range values {
+			if _, err := tx.Exec(createHeaderSQL, name, value, statID); err != nil {
+				_ = tx.Rollback()
+
+				return -1, fmt.Errorf("error creating stat header: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return -1, fmt.Errorf("error creating stat: %v", err)
+	}
+
+	return statID, nil
+}
+
+func (dao StatsPostgresqlImpl) filterStats(
+	condition string, arg interface{}, filter StatsFilter, fn func(Click) error,
+) error {
+	from, to := filter.bounds()
+	query := statsQuery + ` AND ` + condition + ` ORDER BY s.created_at`
+
+	rows, err := dao.db.Query(query, filter.IncludeBots, from, to, arg)
+	if err != nil {
+		return fmt.Errorf("error getting stats: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var click Click
+
+		var shortID int
+
+		err := rows.Scan(
+			&click.CreatedAt, &shortID, &click.Bot, &click.Country, &click.Region, &click.City, &click.Source,
+			&click.Rule, &click.Variant, &click.Referrer, &click.UserAgent,
+		)
+		if err != nil {
+			return fmt.Errorf("error getting stats: %v", err)
+		}
+
+		click.ShortURL = idToShortURL(shortID, chars)
+
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error closing cursor: %v", err)
+	}
+
+	return nil
+}
+
+func (dao StatsPostgresqlImpl) streamByShortID(shortID int, filter StatsFilter, fn func(Click) error) error {
+	return dao.filterStats(`s.short_id = $4`, shortID, filter, fn)
+}
+
+func (dao StatsPostgresqlImpl) streamByUser(user *interface{}, filter StatsFilter, fn func(Click) error) error {
+	userDB, ok := (*user).(*UserPostgresql)
+	if !ok {
+		return errorIncompatibleTypes()
+	}
+
+	return dao.filterStats(`s.user_id = $4`, userDB.ID, filter, fn)
+}
+
+func (dao StatsPostgresqlImpl) findByShortID(shortID int, filter StatsFilter) ([]Click, error) {
+	return collectClicks(func(fn func(Click) error) error {
+		return dao.streamByShortID(shortID, filter, fn)
+	})
+}
+
+func (dao StatsPostgresqlImpl) findAllByUser(user *interface{}, filter StatsFilter) ([]Click, error) {
+	return collectClicks(func(fn func(Click) error) error {
+		return dao.streamByUser(user, filter, fn)
+	})
+}
+
+func (dao StatsPostgresqlImpl) countByShortIDs(ids []int, filter StatsFilter) (map[int]ClickCount, error) {
+	counts := make(map[int]ClickCount, len(ids))
+
+	for _, id := range ids {
+		counts[id] = ClickCount{}
+	}
+
+	from, to := filter.bounds()
+	query := `
+		SELECT short_id, variant, count(*) FROM stats
+		WHERE (bot = false OR $1) AND created_at >= $2 AND created_at < $3 AND short_id = ANY($4)
+		GROUP BY short_id, variant
+	`
+
+	rows, err := dao.db.Query(query, filter.IncludeBots, from, to, pq.Array(ids))
+	if err != nil {
+		return counts, fmt.Errorf("error counting stats: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			shortID, clicks int
+			variant         string
+		)
+
+		if err := rows.Scan(&shortID, &variant, &clicks); err != nil {
+			return counts, fmt.Errorf("error counting stats: %v", err)
+		}
+
+		count := counts[shortID]
+		count.add(variant, clicks)
+		counts[shortID] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return counts, fmt.Errorf("error closing cursor: %v", err)
+	}
+
+	return counts, nil
+}
+
+func (dao WebhookPostgresqlImpl) save(hook Webhook) error {
+	createWebhookSQL := `
+		INSERT INTO webhooks (id, user_key, url, secret, events, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := dao.db.Exec(
+		createWebhookSQL, hook.ID, hook.UserKey, hook.URL, hook.Secret, strings.Join(hook.Events, ","), hook.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (dao WebhookPostgresqlImpl) delete(userKey, id string) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %v", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM webhooks WHERE id = $1 AND user_key = $2`, id, userKey)
+	if err != nil {
+		_ = tx.Rollback()
+
+		return fmt.Errorf("error deleting webhook: %v", err)
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		_ = tx.Rollback()
+
+		return errorWebhookNotFound(id)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = $1`, id); err != nil {
+		_ = tx.Rollback()
+
+		return fmt.Errorf("error deleting webhook deliveries: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error deleting webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (dao WebhookPostgresqlImpl) filterWebhooks(query string, args ...interface{}) ([]Webhook, error) {
+	hooks := []Webhook{}
+
+	rows, err := dao.db.Query(query, args...)
+	if err != nil {
+		return hooks, fmt.Errorf("error getting webhooks: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var hook Webhook
+
+		var events string
+
+		if err := rows.Scan(&hook.ID, &hook.UserKey, &hook.URL, &hook.Secret, &events, &hook.CreatedAt); err != nil {
+			return hooks, fmt.Errorf("error getting webhooks: %v", err)
+		}
+
+		hook.Events = strings.Split(events, ",")
+		hooks = append(hooks, hook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return hooks, fmt.Errorf("error closing cursor: %v", err)
+	}
+
+	return hooks, nil
+}
+
+func (dao WebhookPostgresqlImpl) findByID(userKey, id string) (Webhook, error) {
+	query := `SELECT id, user_key, url, secret, events, created_at FROM webhooks WHERE id = $1 AND user_key = $2`
+
+	hooks, err := dao.filterWebhooks(query, id, userKey)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	if len(hooks) == 0 {
+		return Webhook{}, errorWebhookNotFound(id)
+	}
+
+	return hooks[0], nil
 }
 
-func (dao StatsPostgresqlImpl) findByShortID(shortID int) ([]interface{}, error) {
-	return []interface{}{}, nil
+func (dao WebhookPostgresqlImpl) findAllByUser(userKey string) ([]Webhook, error) {
+	query := `SELECT id, user_key, url, secret, events, created_at FROM webhooks WHERE user_key = $1 ORDER BY created_at`
+
+	return dao.filterWebhooks(query, userKey)
+}
+
+func (dao WebhookPostgresqlImpl) saveDelivery(delivery WebhookDelivery) error {
+	createDeliverySQL := `
+		INSERT INTO webhook_deliveries (id, webhook_id, event_id, event, attempt, status_code, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := dao.db.Exec(
+		createDeliverySQL,
+		delivery.ID, delivery.WebhookID, delivery.EventID, delivery.Event, delivery.Attempt,
+		delivery.StatusCode, delivery.Error, delivery.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating webhook delivery: %v", err)
+	}
+
+	return nil
 }
 
-func (dao StatsPostgresqlImpl) findAllByUser(user *interface{}) ([]interface{}, error) {
-	return []interface{}{}, nil
-}
\ No newline at end of file
+func (dao WebhookPostgresqlImpl) findDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event_id, event, attempt, status_code, error, created_at FROM webhook_deliveries
+		WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT $2
+	`
+
+	deliveries := []WebhookDelivery{}
+
+	rows, err := dao.db.Query(query, webhookID, limit)
+	if err != nil {
+		return deliveries, fmt.Errorf("error getting webhook deliveries: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var d WebhookDelivery
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Attempt, &d.StatusCode, &d.Error, &d.CreatedAt)
+		if err != nil {
+			return deliveries, fmt.Errorf("error getting webhook deliveries: %v", err)
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return deliveries, fmt.Errorf("error closing cursor: %v", err)
+	}
+
+	return deliveries, nil
+}
+
+func (dao BlocklistPostgresqlImpl) save(entry BlocklistEntry) error {
+	createEntrySQL := `INSERT INTO blocklist (id, entry, added_by, created_at) VALUES ($1, $2, $3, $4)`
+
+	_, err := dao.db.Exec(createEntrySQL, entry.ID, entry.Entry, entry.AddedBy, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating blocklist entry: %v", err)
+	}
+
+	return nil
+}
+
+func (dao BlocklistPostgresqlImpl) findAll() ([]BlocklistEntry, error) {
+	query := `SELECT id, entry, added_by, created_at FROM blocklist ORDER BY created_at`
+
+	entries := []BlocklistEntry{}
+
+	rows, err := dao.db.Query(query)
+	if err != nil {
+		return entries, fmt.Errorf("error getting blocklist entries: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e BlocklistEntry
+
+		if err := rows.Scan(&e.ID, &e.Entry, &e.AddedBy, &e.CreatedAt); err != nil {
+			return entries, fmt.Errorf("error getting blocklist entries: %v", err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return entries, fmt.Errorf("error closing cursor: %v", err)
+	}
+
+	return entries, nil
+}
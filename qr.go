@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	qrDefaultSize   = 256
+	qrMaxSize       = 2048
+	qrDefaultMargin = 4
+	qrMaxMargin     = 20
+
+	// qrSource is the value of the source parameter encoded in QR codes, so scans can be told
+	// apart from other clicks in the stats.
+	qrSource = "qr"
+)
+
+// QROptions ...
+type QROptions struct {
+	Size       int
+	Margin     int
+	Level      qrcode.RecoveryLevel
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// parseHexColor parses "rrggbb" or "#rrggbb".
+func parseHexColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, errorInvalidQROption("color", value)
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, errorInvalidQROption("color", value)
+	}
+
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// qrOptions reads the size, margin, level, fg and bg query parameters.
+func qrOptions(c *gin.Context) (QROptions, error) {
+	options := QROptions{
+		Size:       qrDefaultSize,
+		Margin:     qrDefaultMargin,
+		Level:      qrcode.Medium,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+
+	if size := c.Query("size"); size != "" {
+		value, err := strconv.Atoi(size)
+		if err != nil || value <= 0 || value > qrMaxSize {
+			return options, errorInvalidQROption("size", size)
+		}
+
+		options.Size = value
+	}
+
+	if margin := c.Query("margin"); margin != "" {
+		value, err := strconv.Atoi(margin)
+		if err != nil || value < 0 || value > qrMaxMargin {
+			return options, errorInvalidQROption("margin", margin)
+		}
+
+		options.Margin = value
+	}
+
+	if level := c.Query("level"); level != "" {
+		value, ok := qrLevels[strings.ToUpper(level)]
+		if !ok {
+			return options, errorInvalidQROption("level", level)
+		}
+
+		options.Level = value
+	}
+
+	var err error
+
+	if fg := c.Query("fg"); fg != "" {
+		if options.Foreground, err = parseHexColor(fg); err != nil {
+			return options, err
+		}
+	}
+
+	if bg := c.Query("bg"); bg != "" {
+		if options.Background, err = parseHexColor(bg); err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
+}
+
+// qrModules returns the QR code modules of content surrounded by margin empty modules.
+func qrModules(content string, options QROptions) ([][]bool, error) {
+	code, err := qrcode.New(content, options.Level)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding qr code: %w", err)
+	}
+
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+
+	size := len(bitmap) + 2*options.Margin
+	modules := make([][]bool, size)
+
+	for y := range modules {
+		modules[y] = make([]bool, size)
+	}
+
+	for y, row := range bitmap {
+		copy(modules[y+options.Margin][options.Margin:], row)
+	}
+
+	return modules, nil
+}
+
+func qrPNG(modules [][]bool, options QROptions) ([]byte, error) {
+	// Every module gets the same whole number of pixels, the image is never smaller than the code.
+	scale := options.Size / len(modules)
+	if scale < 1 {
+		scale = 1
+	}
+
+	pixels := scale * len(modules)
+	palette := color.Palette{options.Background, options.Foreground}
+	img := image.NewPaletted(image.Rect(0, 0, pixels, pixels), palette)
+
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(x*scale+dx, y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("error encoding png: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func qrSVG(modules [][]bool, options QROptions) []byte {
+	hex := func(c color.RGBA) string {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		options.Size, options.Size, len(modules), len(modules))
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/>`, hex(options.Background))
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hex(options.Foreground))
+
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+
+	buf.WriteString(`"/></svg>`)
+
+	return buf.Bytes()
+}
+
+// shortLinkURL returns the absolute URL of a short link as reached by the current request.
+func shortLinkURL(c *gin.Context, shortURL string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s/u/%s", scheme, c.Request.Host, shortURL)
+}
+
+// serveQRCode answers /u/<code>.png and /u/<code>.svg with a QR code pointing to the short link,
+// any other /u/ request goes through untouched.
+func serveQRCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		param := c.Param("url")
+
+		var format string
+
+		switch {
+		case strings.HasSuffix(param, ".png"):
+			format = "png"
+		case strings.HasSuffix(param, ".svg"):
+			format = "svg"
+		default:
+			return
+		}
+
+		defer c.Abort()
+
+		shortURL := strings.TrimSuffix(param, "."+format)
+		if _, err := (*urlDAO).findByID(shortURLToID(shortURL, chars)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+
+			return
+		}
+
+		options, err := qrOptions(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+
+			return
+		}
+
+		content := shortLinkURL(c, shortURL) + "?" + sourceParam + "=" + qrSource
+
+		modules, err := qrModules(content, options)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+			return
+		}
+
+		c.Header("Cache-Control", "public, max-age=86400")
+
+		if format == "svg" {
+			c.Data(http.StatusOK, "image/svg+xml", qrSVG(modules, options))
+
+			return
+		}
+
+		pngImage, err := qrPNG(modules, options)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+			return
+		}
+
+		c.Data(http.StatusOK, "image/png", pngImage)
+	}
+}
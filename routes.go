@@ -3,17 +3,42 @@ package main
 import "github.com/spf13/viper"
 
 func initializeRoutes(config *viper.Viper) {
+	// Probes are registered before setUserStatus, they must not depend on the session store.
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz)
+
 	router.Use(setUserStatus())
 
 	router.POST("/api/login", generateToken)
 	router.GET("/api/users", viewUsers)
 	router.GET("/api/urls", viewURLs)
+	router.POST("/api/urls/tags", checkUserMiddleware(), editLinks)
+	router.GET("/api/tags", viewTags)
 	router.GET("/api/stats", viewStats)
+	router.GET("/api/stats/locations", viewStatsByLocation)
+	router.GET("/api/stats/export", exportAccountStats)
+	router.GET("/api/links/:url/stats/export", exportLinkStats)
+	router.GET("/api/stats/live", liveAccountClicks)
+	router.GET("/api/links/:url/stats/live", liveLinkClicks)
+	router.GET("/api/links/:url/rules", viewLinkRules)
+	router.PUT("/api/links/:url/rules", updateLinkRules)
+	router.GET("/api/links/:url/variants", viewLinkVariants)
+	router.PUT("/api/links/:url/variants", updateLinkVariants)
+	router.GET("/api/links/:url/stats/variants", viewVariantStats)
+	router.GET("/api/links/:url/schedule", viewLinkSchedule)
+	router.PUT("/api/links/:url/schedule", updateLinkSchedule)
 
-	router.GET("/u/:url", urlStats(), redirectShortURL)
+	// /u/<code>.png and /u/<code>.svg are the QR codes of the link, /u/<code>+ and
+	// /u/<code>?preview its preview, they are not clicks.
+	router.GET("/u/:url", serveQRCode(), serveLinkPreview(), urlStats(), redirectShortURL)
+	// Link unfurlers often probe with HEAD first, those are recorded as bot traffic.
+	router.HEAD("/u/:url", serveQRCode(), serveLinkPreview(), urlStats(), redirectShortURL)
 	router.GET("/", ensureNotLoggedIn(), showIndexPage)
 	router.POST("/u/shorturl", checkUserMiddleware(), shorturl)
 	router.POST("/u/changelink", changeLink)
+	router.POST("/u/deletelink", checkUserMiddleware(), deleteLink)
+	router.GET("/bulk", showBulkPage)
+	router.POST("/bulk", checkUserMiddleware(), bulkShorten)
 	router.POST("/login", login(config))
 	router.GET("/login", ensureNotLoggedIn(), showLoginPage)
 	router.POST("/logout", TokenAuthMiddleware(config), logout(config))
@@ -23,4 +48,22 @@ func initializeRoutes(config *viper.Viper) {
 
 	// stats URLs
 	router.GET("/stats", showStatsPage(config))
+
+	// redirect rules
+	router.GET("/links/:url/rules", showRulesPage)
+	router.POST("/links/:url/rules", editRules)
+	router.POST("/links/:url/variants", editVariants)
+	router.POST("/links/:url/schedule", editSchedule)
+
+	// webhooks
+	router.GET("/webhooks", showWebhooksPage)
+	router.POST("/webhooks", createWebhook)
+	router.POST("/webhooks/:id/delete", deleteWebhook)
+	router.POST("/webhooks/:id/test", testWebhook)
+	router.GET("/api/webhooks", viewWebhooks)
+	router.GET("/api/webhooks/:id/deliveries", viewWebhookDeliveries)
+
+	// administration
+	router.GET("/admin/blocklist", showBlocklistPage)
+	router.POST("/admin/blocklist", addBlocklistEntry)
 }
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxRedirectRules is the number of rules a single link can hold.
+	maxRedirectRules = 20
+
+	// rulesPageBlankRows is the number of empty rules the rules page offers to fill in.
+	rulesPageBlankRows = 3
+)
+
+var ruleOperatingSystems = map[string]bool{
+	osIOS:     true,
+	osAndroid: true,
+	osWindows: true,
+	osMacOS:   true,
+	osLinux:   true,
+	osOther:   true,
+}
+
+var (
+	countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+	languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+)
+
+var ruleDevices = map[string]bool{
+	deviceDesktop: true,
+	deviceMobile:  true,
+	deviceTablet:  true,
+}
+
+// visitor is what the redirect rules know about whoever follows a link.
+type visitor struct {
+	os       string
+	device   string
+	country  string
+	language string
+}
+
+func requestVisitor(r *http.Request) visitor {
+	userAgent := r.Header.Get("User-Agent")
+
+	return visitor{
+		os:       operatingSystem(userAgent),
+		device:   deviceType(userAgent),
+		country:  geoIP.locate(geoIP.clientIP(r)).Country,
+		language: preferredLanguage(r.Header.Get("Accept-Language")),
+	}
+}
+
+// preferredLanguage returns the lowercase language tag with the highest quality in an
+// Accept-Language header, the first one on ties.
+func preferredLanguage(header string) string {
+	preferred, best := "", 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		quality := 1.0
+
+		for _, param := range fields[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				if q, err := strconv.ParseFloat(value[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		if tag != "" && tag != "*" && quality > best {
+			preferred, best = tag, quality
+		}
+	}
+
+	return preferred
+}
+
+func (r RedirectRule) matches(v visitor) bool {
+	return (r.OS == "" || r.OS == v.os) &&
+		(r.Device == "" || r.Device == v.device) &&
+		(r.Country == "" || r.Country == v.country) &&
+		(r.Language == "" || r.Language == v.language || strings.HasPrefix(v.language, r.Language+"-"))
+}
+
+// label describes the conditions of the rule, it is recorded with the clicks the rule matched.
+func (r RedirectRule) label() string {
+	var conditions []string
+
+	for _, condition := range [][2]string{
+		{"os", r.OS}, {"device", r.Device}, {"country", r.Country}, {"language", r.Language},
+	} {
+		if condition[1] != "" {
+			conditions = append(conditions, condition[0]+"="+condition[1])
+		}
+	}
+
+	return strings.Join(conditions, ",")
+}
+
+// destinationFor returns the destination of the first rule matching v along with the label of
+// the rule, URL and an empty label if none does.
+func (u URL) destinationFor(v visitor) (string, string) {
+	for _, rule := range u.Rules {
+		if rule.matches(v) {
+			return rule.Destination, rule.label()
+		}
+	}
+
+	return u.URL, ""
+}
+
+// prepareDestination normalizes and checks an alternative destination of a link the same way
+// shorturl does with the link destination.
+func prepareDestination(destination, requestHost string) (string, error) {
+	normalized, err := urlPolicy.normalize(destination)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := resolveShortLinkChain(normalized, requestHost)
+	if err != nil {
+		return "", err
+	}
+
+	if entry, blocked := blocklist.match(resolved); blocked {
+		return "", errorBlockedDestination(entry)
+	}
+
+	return resolved, nil
+}
+
+// prepareRules validates the rules and their destinations the same way shorturl validates a
+// link, rules are numbered from 1 in the errors.
+func prepareRules(rules []RedirectRule, requestHost string) ([]RedirectRule, error) {
+	if len(rules) > maxRedirectRules {
+		return nil, errorInvalidRule(maxRedirectRules+1, fmt.Sprintf("no more than %d rules are allowed", maxRedirectRules))
+	}
+
+	prepared := make([]RedirectRule, 0, len(rules))
+
+	for i, rule := range rules {
+		rule.OS = strings.ToLower(strings.TrimSpace(rule.OS))
+		rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+		rule.Country = strings.ToUpper(strings.TrimSpace(rule.Country))
+		rule.Language = strings.ToLower(strings.TrimSpace(rule.Language))
+
+		if rule.OS != "" && !ruleOperatingSystems[rule.OS] {
+			return nil, errorInvalidRule(i+1, "unknown operating system "+rule.OS)
+		}
+
+		if rule.Device != "" && !ruleDevices[rule.Device] {
+			return nil, errorInvalidRule(i+1, "unknown device "+rule.Device)
+		}
+
+		if rule.Country != "" && !countryCode.MatchString(rule.Country) {
+			return nil, errorInvalidRule(i+1, "the country must be a two letter code")
+		}
+
+		if rule.Language != "" && !languageTag.MatchString(rule.Language) {
+			return nil, errorInvalidRule(i+1, "invalid language "+rule.Language)
+		}
+
+		if rule.label() == "" {
+			return nil, errorInvalidRule(i+1, "an operating system, device, country or language is required")
+		}
+
+		destination, err := prepareDestination(rule.Destination, requestHost)
+		if err != nil {
+			return nil, errorInvalidRule(i+1, err.Error())
+		}
+
+		rule.Destination = destination
+		prepared = append(prepared, rule)
+	}
+
+	return prepared, nil
+}
+
+// ownedLink returns the link of the url parameter when it belongs to the session user.
+func ownedLink(c *gin.Context) (int, URL, bool) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		return 0, URL{}, false
+	}
+
+	id := shortURLToID(c.Param("url"), chars)
+
+	if owned, err := userOwnsURL(&userFound, id); err != nil || !owned {
+		return 0, URL{}, false
+	}
+
+	url, err := (*urlDAO).findByID(id)
+	if err != nil {
+		return 0, URL{}, false
+	}
+
+	return id, url, true
+}
+
+func saveLinkRules(c *gin.Context, id int, rules []RedirectRule) ([]RedirectRule, error) {
+	prepared, err := prepareRules(rules, c.Request.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := (*urlDAO).saveRules(id, prepared); err != nil {
+		return nil, err
+	}
+
+	dispatchLinkEvent(id, eventLinkChanged, LinkEventData{ShortURL: c.Param("url")})
+
+	return prepared, nil
+}
+
+// viewLinkRules returns the default destination and the redirect rules of a link.
+func viewLinkRules(c *gin.Context) {
+	_, url, ok := ownedLink(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("link %s not found", c.Param("url"))})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":   url.URL,
+		"rules": url.Rules,
+	})
+}
+
+// updateLinkRules replaces the redirect rules of a link, an empty list removes them all.
+func updateLinkRules(c *gin.Context) {
+	id, _, ok := ownedLink(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("link %s not found", c.Param("url"))})
+
+		return
+	}
+
+	var request struct {
+		Rules []RedirectRule `json:"rules"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	rules, err := saveLinkRules(c, id, request.Rules)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+func renderRulesPage(c *gin.Context, status int, url URL, data gin.H) {
+	data["title"] = "Rules and schedule"
+	data["short_url"] = c.Param("url")
+	data["url"] = url
+	data["operating_systems"] = sortedKeys(ruleOperatingSystems)
+	data["devices"] = sortedKeys(ruleDevices)
+	data["blank_rows"] = make([]struct{}, rulesPageBlankRows)
+	data["live"] = url.active(time.Now())
+	data["current_destination"] = url.scheduledDestination(time.Now())
+
+	c.HTML(status, "rules.html", data)
+}
+
+func showRulesPage(c *gin.Context) {
+	_, url, ok := ownedLink(c)
+	if !ok {
+		c.HTML(
+			http.StatusNotFound,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": fmt.Sprintf(`Link %s not found`, c.Param("url")),
+			},
+		)
+
+		return
+	}
+
+	renderRulesPage(c, http.StatusOK, url, gin.H{})
+}
+
+// editRules saves the rules of the rules page, rows without a destination are dropped.
+func editRules(c *gin.Context) {
+	id, url, ok := ownedLink(c)
+	if !ok {
+		c.HTML(
+			http.StatusNotFound,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": fmt.Sprintf(`Link %s not found`, c.Param("url")),
+			},
+		)
+
+		return
+	}
+
+	operatingSystems := c.PostFormArray("os")
+	devices := c.PostFormArray("device")
+	countries := c.PostFormArray("country")
+	languages := c.PostFormArray("language")
+	destinations := c.PostFormArray("destination")
+
+	var rules []RedirectRule
+
+	for i, destination := range destinations {
+		if strings.TrimSpace(destination) == "" || i >= len(operatingSystems) || i >= len(devices) ||
+			i >= len(countries) || i >= len(languages) {
+			continue
+		}
+
+		rules = append(rules, RedirectRule{
+			OS:          operatingSystems[i],
+			Device:      devices[i],
+			Country:     countries[i],
+			Language:    languages[i],
+			Destination: destination,
+		})
+	}
+
+	saved, err := saveLinkRules(c, id, rules)
+	if err != nil {
+		url.Rules = rules
+		renderRulesPage(c, http.StatusUnprocessableEntity, url, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	url.Rules = saved
+	renderRulesPage(c, http.StatusOK, url, gin.H{"Message": "Redirect rules saved."})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxScheduledDestinations = 10
+
+	// scheduleTimeLayout is the layout of datetime-local inputs, those times are taken as UTC.
+	scheduleTimeLayout = "2006-01-02T15:04"
+)
+
+// active tells whether the link has gone live, links without ActiveFrom always have.
+func (u URL) active(now time.Time) bool {
+	return u.ActiveFrom == nil || !now.Before(*u.ActiveFrom)
+}
+
+// scheduledDestination returns the destination of the last schedule entry started by now, URL
+// when none has.
+func (u URL) scheduledDestination(now time.Time) string {
+	destination := u.URL
+
+	for _, entry := range u.Schedule {
+		if now.Before(entry.From) {
+			break
+		}
+
+		destination = entry.Destination
+	}
+
+	return destination
+}
+
+// parseScheduleTime reads RFC 3339, datetime-local (UTC) or YYYY-MM-DD (start of the day, UTC) times.
+func parseScheduleTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if t, err := time.Parse(scheduleTimeLayout, value); err == nil {
+		return t, nil
+	}
+
+	return parseStatsDate(value, false)
+}
+
+// prepareSchedule validates the destinations of the schedule the same way shorturl validates a
+// link and sorts the entries by start time, entries are numbered from 1 in the errors.
+func prepareSchedule(schedule []ScheduledDestination, requestHost string) ([]ScheduledDestination, error) {
+	if len(schedule) > maxScheduledDestinations {
+		return nil, errorInvalidSchedule(
+			maxScheduledDestinations+1, fmt.Sprintf("no more than %d entries are allowed", maxScheduledDestinations),
+		)
+	}
+
+	prepared := make([]ScheduledDestination, 0, len(schedule))
+	starts := map[time.Time]bool{}
+
+	for i, entry := range schedule {
+		if entry.From.IsZero() {
+			return nil, errorInvalidSchedule(i+1, "a start time is required")
+		}
+
+		entry.From = entry.From.UTC()
+
+		if starts[entry.From] {
+			return nil, errorInvalidSchedule(i+1, "another entry starts at the same time")
+		}
+
+		destination, err := prepareDestination(entry.Destination, requestHost)
+		if err != nil {
+			return nil, errorInvalidSchedule(i+1, err.Error())
+		}
+
+		entry.Destination = destination
+		starts[entry.From] = true
+		prepared = append(prepared, entry)
+	}
+
+	sort.Slice(prepared, func(i, j int) bool {
+		return prepared[i].From.Before(prepared[j].From)
+	})
+
+	return prepared, nil
+}
+
+func saveLinkSchedule(
+	c *gin.Context, id int, activeFrom *time.Time, schedule []ScheduledDestination,
+) ([]ScheduledDestination, error) {
+	prepared, err := prepareSchedule(schedule, c.Request.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if activeFrom != nil {
+		utc := activeFrom.UTC()
+		activeFrom = &utc
+	}
+
+	if err := (*urlDAO).saveSchedule(id, activeFrom, prepared); err != nil {
+		return nil, err
+	}
+
+	dispatchLinkEvent(id, eventLinkChanged, LinkEventData{ShortURL: c.Param("url")})
+
+	return prepared, nil
+}
+
+// renderNotYetAvailable answers the visits to links that have not gone live, sending them to
+// NOT_YET_AVAILABLE_URL when there is one.
+func renderNotYetAvailable(c *gin.Context, shortURL string) {
+	// The answer changes at launch time, it must not be cached.
+	c.Header("Cache-Control", "no-store")
+
+	if notYetAvailableURL != "" {
+		c.Redirect(http.StatusFound, notYetAvailableURL)
+
+		return
+	}
+
+	c.HTML(
+		http.StatusNotFound,
+		"notyet.html",
+		gin.H{
+			"title":     "Not available yet",
+			"short_url": shortURL,
+		},
+	)
+}
+
+// viewLinkSchedule returns the activation time and the destination schedule of a link.
+func viewLinkSchedule(c *gin.Context) {
+	_, url, ok := ownedLink(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("link %s not found", c.Param("url"))})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":         url.URL,
+		"active_from": url.ActiveFrom,
+		"schedule":    url.Schedule,
+	})
+}
+
+// updateLinkSchedule replaces the activation time and the schedule of a link, a null active_from
+// makes the link live and an empty schedule removes it.
+func updateLinkSchedule(c *gin.Context) {
+	id, _, ok := ownedLink(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("link %s not found", c.Param("url"))})
+
+		return
+	}
+
+	var request struct {
+		ActiveFrom *time.Time             `json:"active_from"`
+		Schedule   []ScheduledDestination `json:"schedule"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	schedule, err := saveLinkSchedule(c, id, request.ActiveFrom, request.Schedule)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active_from": request.ActiveFrom,
+		"schedule":    schedule,
+	})
+}
+
+// editSchedule saves the activation time and the schedule of the rules page, rows without a
+// destination are dropped.
+func editSchedule(c *gin.Context) {
+	id, url, ok := ownedLink(c)
+	if !ok {
+		c.HTML(
+			http.StatusNotFound,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": fmt.Sprintf(`Link %s not found`, c.Param("url")),
+			},
+		)
+
+		return
+	}
+
+	var activeFrom *time.Time
+
+	if value := strings.TrimSpace(c.PostForm("active_from")); value != "" {
+		from, err := parseScheduleTime(value)
+		if err != nil {
+			renderRulesPage(c, http.StatusUnprocessableEntity, url, gin.H{"ErrorMessage": err.Error()})
+
+			return
+		}
+
+		activeFrom = &from
+	}
+
+	starts := c.PostFormArray("from")
+	destinations := c.PostFormArray("destination")
+
+	var schedule []ScheduledDestination
+
+	for i, destination := range destinations {
+		if strings.TrimSpace(destination) == "" || i >= len(starts) {
+			continue
+		}
+
+		entry := ScheduledDestination{Destination: destination}
+
+		if strings.TrimSpace(starts[i]) != "" {
+			from, err := parseScheduleTime(starts[i])
+			if err != nil {
+				renderRulesPage(c, http.StatusUnprocessableEntity, url, gin.H{
+					"ErrorMessage": errorInvalidSchedule(len(schedule)+1, err.Error()).Error(),
+				})
+
+				return
+			}
+
+			entry.From = from
+		}
+
+		schedule = append(schedule, entry)
+	}
+
+	saved, err := saveLinkSchedule(c, id, activeFrom, schedule)
+	if err != nil {
+		url.ActiveFrom, url.Schedule = activeFrom, schedule
+		renderRulesPage(c, http.StatusUnprocessableEntity, url, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	url.ActiveFrom, url.Schedule = activeFrom, saved
+	renderRulesPage(c, http.StatusOK, url, gin.H{"Message": "Schedule saved."})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// maxShortLinkHops is how many littleu links are followed before a chain is treated as a loop.
+	maxShortLinkHops = 5
+
+	shortLinkPathPrefix = "/u/"
+)
+
+// ownDomains builds the set of hosts littleu is reachable at from LITTLEU_DOMAINS, entries
+// without a port match every port. The host of each request is checked too, see isOwnHost.
+func ownDomains(config *viper.Viper) map[string]bool {
+	domains := map[string]bool{}
+
+	for _, domain := range strings.Split(config.GetString("LITTLEU_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains[domain] = true
+		}
+	}
+
+	return domains
+}
+
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
+
+// isOwnHost tells whether host, as found in a destination, is littleu: one of the configured
+// domains or the host, port included, the request was sent to.
+func isOwnHost(host, requestHost string) bool {
+	host = strings.ToLower(host)
+
+	return littleuDomains[host] || littleuDomains[hostWithoutPort(host)] || host == strings.ToLower(requestHost)
+}
+
+// resolveShortLinkChain follows destinations pointing back at littleu short links until it
+// reaches an outside URL, which is returned. Hops are followed to their stored destination:
+// their rules, variants, schedule and UTM parameters only apply to visitors of the hop itself,
+// and the click is not counted on it. A hop which is disabled, expired or not live yet is
+// returned as is, visitors land on it as if they had followed it directly. Links to other
+// littleu pages, unknown codes, and chains that come back to an already visited link (or to
+// seen) are rejected.
+func resolveShortLinkChain(destination, requestHost string, seen ...int) (string, error) {
+	visited := map[int]bool{}
+	for _, id := range seen {
+		visited[id] = true
+	}
+
+	for hops := 0; ; hops++ {
+		u, err := url.Parse(destination)
+		if err != nil || !isOwnHost(u.Host, requestHost) {
+			return destination, nil
+		}
+
+		if !strings.HasPrefix(u.Path, shortLinkPathPrefix) {
+			return "", errorInvalidDestination("littleu pages cannot be shortened")
+		}
+
+		id := shortURLToID(strings.TrimPrefix(u.Path, shortLinkPathPrefix), chars)
+		if visited[id] || hops >= maxShortLinkHops {
+			return "", errorRedirectLoop(destination)
+		}
+
+		visited[id] = true
+
+		next, err := (*urlDAO).findByID(id)
+		if err != nil || next.URL == "" {
+			return "", errorInvalidDestination("the littleu link " + destination + " does not exist")
+		}
+
+		if now := time.Now(); next.Disabled || next.expired(now) || !next.active(now) {
+			return destination, nil
+		}
+
+		destination = next.URL
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResolveShortLinkChain(t *testing.T) {
+	useMemoryEngine(t)
+
+	user := addTestUser(t, "chains")
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	save := func(url URL) string {
+		t.Helper()
+
+		id, err := (*urlDAO).save(url, &user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return "https://lu.test/u/" + idToShortURL(id, chars)
+	}
+
+	live := save(URL{URL: "https://example.org/"})
+	viaLive := save(URL{URL: live})
+	expired := save(URL{URL: "https://example.org/expired", ExpiresAt: &past})
+	notLiveYet := save(URL{URL: "https://example.org/soon", ActiveFrom: &future})
+	viaExpired := save(URL{URL: expired})
+
+	disabled := save(URL{URL: "https://example.org/disabled"})
+	if err := (*urlDAO).disable(shortURLToID(disabled[len("https://lu.test/u/"):], chars)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the stored destination of a hop is followed.
+	withRouting := save(URL{URL: "https://example.org/plain", UTM: UTMParams{Source: "chain"}})
+	withRoutingID := shortURLToID(withRouting[len("https://lu.test/u/"):], chars)
+
+	rules := []RedirectRule{{Device: "desktop", Destination: "https://example.org/rule"}}
+	if err := (*urlDAO).saveRules(withRoutingID, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	variants := []Variant{{Name: "b", Destination: "https://example.org/b", Weight: 1}}
+	if err := (*urlDAO).saveVariants(withRoutingID, variants, false); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := []ScheduledDestination{{From: past, Destination: "https://example.org/scheduled"}}
+	if err := (*urlDAO).saveSchedule(withRoutingID, nil, schedule); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		destination string
+		seen        []int
+		want        string
+		err         error
+	}{
+		{destination: "https://example.net/", want: "https://example.net/"},
+		{destination: live, want: "https://example.org/"},
+		{destination: viaLive, want: "https://example.org/"},
+		{destination: expired, want: expired},
+		{destination: notLiveYet, want: notLiveYet},
+		{destination: disabled, want: disabled},
+		{destination: viaExpired, want: expired},
+		{destination: withRouting, want: "https://example.org/plain"},
+		{destination: "https://lu.test/u/zzzzzz", err: errInvalidDestination},
+		{destination: "https://lu.test/login", err: errInvalidDestination},
+		{destination: live, seen: []int{shortURLToID(live[len("https://lu.test/u/"):], chars)}, err: errRedirectLoop},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveShortLinkChain(tt.destination, "lu.test", tt.seen...)
+
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("resolveShortLinkChain(%q) error = %v, want %v", tt.destination, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("resolveShortLinkChain(%q) = %q, %v, want %q", tt.destination, got, err, tt.want)
+		}
+	}
+}
+
+func TestFindActiveByUser(t *testing.T) {
+	useMemoryEngine(t)
+
+	user := addTestUser(t, "reuser")
+	future := time.Now().Add(time.Hour)
+
+	if _, err := (*urlDAO).save(URL{URL: "https://example.org/", ActiveFrom: &future}, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := (*urlDAO).findActiveByUser(&user, "https://example.org/"); err != nil || found {
+		t.Fatalf("findActiveByUser() = %v, %v, a link which is not live yet was reused", found, err)
+	}
+
+	id, err := (*urlDAO).save(URL{URL: "https://example.org/"}, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, found, err := (*urlDAO).findActiveByUser(&user, "https://example.org/"); err != nil || !found || got != id {
+		t.Fatalf("findActiveByUser() = %d, %v, %v, want %d", got, found, err, id)
+	}
+}
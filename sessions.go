@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	redisSession "github.com/gin-contrib/sessions/redis"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// sessionStoreRedis keeps the sessions in Redis, shared by every instance.
+	sessionStoreRedis = "redis"
+	// sessionStoreCookie keeps the sessions in cookies signed with SESSION_SECRET and encrypted
+	// with SESSION_ENCRYPTION_KEY, which is required. No storage is involved.
+	sessionStoreCookie = "cookie"
+	// sessionStoreMemory keeps the sessions in the process, they are lost on restarts and not
+	// shared between instances.
+	sessionStoreMemory = "memory"
+	// sessionStorePostgres and sessionStoreMongo keep the sessions in the sessions table or
+	// collection, the cookie only holds the signed session ID.
+	sessionStorePostgres = "postgresql"
+	sessionStoreMongo    = "mongo"
+
+	// DefaultSessionStore ...
+	DefaultSessionStore = sessionStoreRedis
+
+	// DefaultSessionMaxAge is how long sessions last, SESSION_MAX_AGE.
+	DefaultSessionMaxAge = 30 * 24 * time.Hour
+
+	// sessionPurgeInterval is how often expired sessions are removed from the memory, Postgres
+	// and Mongo stores.
+	sessionPurgeInterval = time.Hour
+)
+
+var sessionSameSite = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// sessionStoreName returns SESSION_STORE in lowercase.
+func sessionStoreName(config *viper.Viper) string {
+	return strings.ToLower(strings.TrimSpace(config.GetString("SESSION_STORE")))
+}
+
+// sessionKeys returns the SESSION_SECRET signing key and the optional SESSION_ENCRYPTION_KEY,
+// which must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256).
+func sessionKeys(config *viper.Viper) ([][]byte, error) {
+	keys := [][]byte{[]byte(config.GetString("SESSION_SECRET"))}
+
+	encryptionKey := config.GetString("SESSION_ENCRYPTION_KEY")
+	if encryptionKey == "" {
+		return keys, nil
+	}
+
+	if n := len(encryptionKey); n != 16 && n != 24 && n != 32 {
+		return nil, fmt.Errorf("SESSION_ENCRYPTION_KEY must be 16, 24 or 32 bytes long, not %d", n)
+	}
+
+	return append(keys, []byte(encryptionKey)), nil
+}
+
+// sessionOptions reads the cookie flags: SESSION_SECURE, SESSION_SAMESITE (lax, strict or none),
+// SESSION_DOMAIN and SESSION_MAX_AGE.
+func sessionOptions(config *viper.Viper) (sessions.Options, error) {
+	sameSite, ok := sessionSameSite[strings.ToLower(strings.TrimSpace(config.GetString("SESSION_SAMESITE")))]
+	if !ok {
+		return sessions.Options{}, fmt.Errorf(
+			"unknown SESSION_SAMESITE %q, expected lax, strict or none", config.GetString("SESSION_SAMESITE"),
+		)
+	}
+
+	maxAge := config.GetDuration("SESSION_MAX_AGE")
+	if maxAge < 0 {
+		return sessions.Options{}, fmt.Errorf("SESSION_MAX_AGE cannot be negative")
+	}
+
+	// Browsers refuse SameSite=None cookies that are not Secure.
+	if sameSite == http.SameSiteNoneMode && !config.GetBool("SESSION_SECURE") {
+		return sessions.Options{}, fmt.Errorf("SESSION_SAMESITE=none needs SESSION_SECURE=true")
+	}
+
+	return sessions.Options{
+		Path:     "/",
+		Domain:   config.GetString("SESSION_DOMAIN"),
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   config.GetBool("SESSION_SECURE"),
+		HttpOnly: true,
+		SameSite: sameSite,
+	}, nil
+}
+
+// newSessionStore creates the SESSION_STORE store with the cookie flags of sessionOptions.
+// mongoClient and db are only used by the mongo and postgresql stores.
+func newSessionStore(config *viper.Viper, mongoClient *mongo.Client, db *sql.DB) (sessions.Store, error) {
+	keys, err := sessionKeys(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cookieOptions, err := sessionOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var store sessions.Store
+
+	switch name := sessionStoreName(config); name {
+	case sessionStoreRedis:
+		if store, err = redisSession.NewStore(MaxIdleConnections, "tcp", redisDSN(config), "", keys...); err == nil {
+			if getErr, rediStore := redisSession.GetRedisStore(store); getErr == nil {
+				registerCloser("redis sessions", rediStore.Close)
+			}
+		}
+	case sessionStoreCookie:
+		// The cookie holds the whole session, the logged in user and their password hash
+		// included, so it must not be readable.
+		if len(keys) < 2 {
+			err = errors.New("SESSION_ENCRYPTION_KEY is required")
+		} else {
+			store = cookie.NewStore(keys...)
+		}
+	case sessionStoreMemory:
+		store = newBackendStore(&memorySessions{sessions: map[string]memorySession{}}, keys)
+	case sessionStorePostgres:
+		var backend *postgresSessions
+
+		if backend, err = newPostgresSessions(db); err == nil {
+			store = newBackendStore(backend, keys)
+		}
+	case sessionStoreMongo:
+		var backend *mongoSessions
+
+		if backend, err = newMongoSessions(mongoClient); err == nil {
+			store = newBackendStore(backend, keys)
+		}
+	default:
+		return nil, fmt.Errorf("unknown session store %q, expected redis, cookie, memory, postgresql or mongo", name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating the %s session store: %w", sessionStoreName(config), err)
+	}
+
+	if backend, ok := store.(*backendStore); ok {
+		registerCloser("session purge", backend.close)
+	}
+
+	store.Options(cookieOptions)
+
+	return store, nil
+}
+
+// sessionBackend keeps the encoded values of server side sessions, sessions past their expiry
+// time are not loaded.
+type sessionBackend interface {
+	load(id string, now time.Time) ([]byte, bool, error)
+	save(id string, data []byte, expires time.Time) error
+	delete(id string) error
+	purge(now time.Time) error
+}
+
+// backendStore is a gorilla session store keeping the values in a sessionBackend, the cookie
+// holds the signed (and encrypted with a second key) session ID.
+type backendStore struct {
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+	backend sessionBackend
+
+	// stop ends the purge of expired sessions, see close.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newBackendStore(backend sessionBackend, keys [][]byte) *backendStore {
+	s := &backendStore{
+		codecs:  securecookie.CodecsFromPairs(keys...),
+		options: &gsessions.Options{Path: "/", MaxAge: int(DefaultSessionMaxAge.Seconds()), HttpOnly: true},
+		backend: backend,
+		stop:    make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(sessionPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				if err := s.backend.purge(now); err != nil {
+					log.Printf("error removing expired sessions: %v", err)
+				}
+			}
+		}
+	}()
+
+	return s
+}
+
+// close stops purging expired sessions, before the connection of the backend is closed.
+func (s *backendStore) close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	return nil
+}
+
+// Options sets the cookie flags, the cookie and its signature expire with MaxAge.
+func (s *backendStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+
+	for _, codec := range s.codecs {
+		if c, ok := codec.(*securecookie.SecureCookie); ok {
+			c.MaxAge(options.MaxAge)
+		}
+	}
+}
+
+// Get returns the session of the request, cached for the rest of the request.
+func (s *backendStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session of the request cookie, or starts a new one when there is none or it
+// expired.
+func (s *backendStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.codecs...); err != nil {
+		return session, err
+	}
+
+	data, found, err := s.backend.load(id, time.Now())
+	if err != nil || !found {
+		return session, err
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+
+	session.ID = id
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save stores the session and sets its cookie, a negative MaxAge deletes both.
+func (s *backendStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.delete(session.ID); err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+
+	// Browser sessions (MaxAge 0) are kept for the default max age.
+	maxAge := time.Duration(session.Options.MaxAge) * time.Second
+	if maxAge == 0 {
+		maxAge = DefaultSessionMaxAge
+	}
+
+	if err := s.backend.save(session.ID, data.Bytes(), time.Now().Add(maxAge)); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// memorySessions keeps the sessions in the process.
+type memorySessions struct {
+	mu       sync.RWMutex
+	sessions map[string]memorySession
+}
+
+func (m *memorySessions) load(id string, now time.Time) ([]byte, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[id]
+	if !ok || !now.Before(session.expires) {
+		return nil, false, nil
+	}
+
+	return session.data, true, nil
+}
+
+func (m *memorySessions) save(id string, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = memorySession{data: data, expires: expires}
+
+	return nil
+}
+
+func (m *memorySessions) delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+func (m *memorySessions) purge(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if !now.Before(session.expires) {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+// postgresSessions keeps the sessions in the sessions table of POSTGRES_DSN.
+type postgresSessions struct {
+	db *sql.DB
+}
+
+func newPostgresSessions(db *sql.DB) (*postgresSessions, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id text PRIMARY KEY,
+			data bytea NOT NULL,
+			expires_at timestamptz NOT NULL
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresSessions{db: db}, nil
+}
+
+func (p *postgresSessions) load(id string, now time.Time) ([]byte, bool, error) {
+	var data []byte
+
+	err := p.db.QueryRow(`SELECT data FROM sessions WHERE id = $1 AND expires_at > $2`, id, now).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("error loading session: %v", err)
+	}
+
+	return data, true, nil
+}
+
+func (p *postgresSessions) save(id string, data []byte, expires time.Time) error {
+	_, err := p.db.Exec(`
+		INSERT INTO sessions (id, data, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, expires_at = excluded.expires_at
+	`, id, data, expires)
+	if err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+
+	return nil
+}
+
+func (p *postgresSessions) delete(id string) error {
+	if _, err := p.db.Exec(`DELETE FROM sessions WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error deleting session: %v", err)
+	}
+
+	return nil
+}
+
+func (p *postgresSessions) purge(now time.Time) error {
+	_, err := p.db.Exec(`DELETE FROM sessions WHERE expires_at <= $1`, now)
+
+	return err
+}
+
+type sessionDocument struct {
+	ID        string    `bson:"_id"`
+	Data      []byte    `bson:"data"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// mongoSessions keeps the sessions in the sessions collection, Mongo removes the expired ones
+// through a TTL index.
+type mongoSessions struct {
+	collection *mongo.Collection
+}
+
+func newMongoSessions(client *mongo.Client) (*mongoSessions, error) {
+	if client == nil {
+		return nil, errors.New("mongo is not connected")
+	}
+
+	collection := client.Database("littleu").Collection("sessions")
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &mongoSessions{collection: collection}, nil
+}
+
+func (m *mongoSessions) load(id string, now time.Time) ([]byte, bool, error) {
+	var doc sessionDocument
+
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}},
+	}
+
+	if err := m.collection.FindOne(ctx, filter).Decode(&doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("error loading session: %w", err)
+	}
+
+	return doc.Data, true, nil
+}
+
+func (m *mongoSessions) save(id string, data []byte, expires time.Time) error {
+	_, err := m.collection.ReplaceOne(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		sessionDocument{ID: id, Data: data, ExpiresAt: expires},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("error saving session: %w", err)
+	}
+
+	return nil
+}
+
+func (m *mongoSessions) delete(id string) error {
+	if _, err := m.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}}); err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+
+	return nil
+}
+
+// purge leaves the expired sessions to the TTL index.
+func (m *mongoSessions) purge(_ time.Time) error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+func TestNewSessionStore(t *testing.T) {
+	tests := []struct {
+		name          string
+		store         string
+		encryptionKey string
+		err           bool
+	}{
+		{name: "memory", store: sessionStoreMemory},
+		{name: "memory with encryption", store: sessionStoreMemory, encryptionKey: strings.Repeat("k", 32)},
+		{name: "cookie with encryption", store: sessionStoreCookie, encryptionKey: strings.Repeat("k", 16)},
+		{name: "cookie without encryption", store: sessionStoreCookie, err: true},
+		{name: "bad encryption key", store: sessionStoreMemory, encryptionKey: "short", err: true},
+		{name: "unknown store", store: "files", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := viper.New()
+			config.Set("SESSION_SECRET", "secret")
+			config.Set("SESSION_STORE", tt.store)
+			config.Set("SESSION_ENCRYPTION_KEY", tt.encryptionKey)
+
+			store, err := newSessionStore(config, nil, nil)
+
+			if tt.err {
+				if err == nil {
+					t.Fatal("newSessionStore() succeeded, want an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if backend, ok := store.(*backendStore); ok {
+				t.Cleanup(func() { _ = backend.close() })
+			}
+
+			testSessionRoundTrip(t, store)
+		})
+	}
+}
+
+// testSessionRoundTrip saves a value in a session and reads it back with the returned cookie.
+func testSessionRoundTrip(t *testing.T, store sessions.Store) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(sessions.Sessions("sid", store))
+	router.GET("/set", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("user", "alice")
+
+		if err := session.Save(); err != nil {
+			t.Error(err)
+		}
+	})
+	router.GET("/get", func(c *gin.Context) {
+		c.String(http.StatusOK, "%v", sessions.Default(c).Get("user"))
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/set", nil))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("%d cookies set, want 1", len(cookies))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/get", nil)
+	req.AddCookie(cookies[0])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Body.String() != "alice" {
+		t.Fatalf("session value = %q, want %q", w.Body.String(), "alice")
+	}
+}
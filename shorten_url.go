@@ -3,6 +3,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -45,9 +46,48 @@ func checkUserMiddleware() gin.HandlerFunc {
 	}
 }
 
+// wantsJSON tells whether the client asked for a JSON response, as render does.
+func wantsJSON(c *gin.Context) bool {
+	return c.Request.Header.Get("Accept") == "application/json"
+}
+
+// shortenError reports a failure to shorten a URL either in the JSON API or on index.html.
+func shortenError(c *gin.Context, status int, err error) {
+	if wantsJSON(c) {
+		c.JSON(status, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	c.HTML(
+		status,
+		"index.html",
+		gin.H{
+			"title":        "Home",
+			"url":          c.PostForm("url"),
+			"ErrorTitle":   "Error shortening URL",
+			"ErrorMessage": err.Error(),
+		},
+	)
+}
+
+// shortenRequest is the body of /u/shorturl, New asks for a new short link even if the user
+// already has one for the same destination.
+type shortenRequest struct {
+	URL
+	New bool `form:"new" json:"new"`
+	// TagList is the comma separated tags field of the form, JSON clients send URL.Tags instead.
+	TagList string `form:"tags" json:"-"`
+	// ActiveFromField is the active_from field of the form, JSON clients send URL.ActiveFrom.
+	ActiveFromField string `form:"active_from" json:"-"`
+}
+
 func shorturl(c *gin.Context) {
-	var url URL
-	_ = c.ShouldBind(&url)
+	var request shortenRequest
+	_ = c.ShouldBind(&request)
+
+	url := request.URL
+	url.Tags = normalizeTags(append(url.Tags, splitTags(request.TagList)...))
 
 	session := sessions.Default(c)
 	userFound := session.Get("user_logged_in")
@@ -65,9 +105,105 @@ func shorturl(c *gin.Context) {
 		return
 	}
 
-	id, _ := (*urlDAO).save(url, &userFound)
+	normalized, err := urlPolicy.normalize(url.URL)
+	if err != nil {
+		shortenError(c, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	// Shortening one of our own links would only add a hop, the underlying target is saved instead.
+	resolved, err := resolveShortLinkChain(normalized, c.Request.Host)
+	if err != nil {
+		shortenError(c, http.StatusBadRequest, err)
+
+		return
+	}
+
+	if entry, blocked := blocklist.match(resolved); blocked {
+		shortenError(c, http.StatusUnprocessableEntity, errorBlockedDestination(entry))
+
+		return
+	}
+
+	url.URL = resolved
+
+	if url.Folder, err = normalizeFolder(url.Folder); err != nil {
+		shortenError(c, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	if url.UTM, err = normalizeUTM(url.UTM); err != nil {
+		shortenError(c, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	if url.QueryPolicy, err = normalizeQueryPolicy(url.QueryPolicy); err != nil {
+		shortenError(c, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	if request.ActiveFromField != "" {
+		activeFrom, err := parseScheduleTime(request.ActiveFromField)
+		if err != nil {
+			shortenError(c, http.StatusUnprocessableEntity, err)
+
+			return
+		}
+
+		url.ActiveFrom = &activeFrom
+	}
+
+	if url.Alias != "" {
+		if err := validateAlias(url.Alias); err != nil {
+			shortenError(c, http.StatusUnprocessableEntity, err)
+
+			return
+		}
+	}
+
+	var (
+		id       int
+		existing bool
+	)
+
+	// Shortening the same destination again gives back the user's existing link, unless a
+	// specific alias, different UTM parameters or a launch time are asked for.
+	if !request.New && url.Alias == "" && url.UTM == (UTMParams{}) && url.QueryPolicy == "" && url.ActiveFrom == nil {
+		id, existing, err = (*urlDAO).findActiveByUser(&userFound, url.URL)
+		if err != nil {
+			shortenError(c, http.StatusInternalServerError, err)
+
+			return
+		}
+	}
+
+	if !existing {
+		id, err = (*urlDAO).save(url, &userFound)
+		if errors.Is(err, errAliasTaken) {
+			shortenError(c, http.StatusConflict, err)
+
+			return
+		}
+
+		if err != nil {
+			shortenError(c, http.StatusInternalServerError, err)
+
+			return
+		}
+
+		metadataFetcher.enqueue(id, url.URL)
+	}
+
 	shortURL := idToShortURL(id, chars)
 
+	if key, ok := userKey(&userFound); ok && !existing {
+		dispatchEvent(key, eventLinkCreated, LinkEventData{ShortURL: shortURL, URL: url.URL})
+	}
+
 	fqdnHostName, err := fqdn.FqdnHostname()
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
@@ -77,6 +213,17 @@ func shorturl(c *gin.Context) {
 
 	littleuLink := fmt.Sprintf("%s/u/%s", domain, shortURL)
 
+	if wantsJSON(c) {
+		c.JSON(http.StatusOK, gin.H{
+			"url":          url.URL,
+			"short_url":    shortURL,
+			"littleu_link": littleuLink,
+			"existing":     existing,
+		})
+
+		return
+	}
+
 	c.HTML(
 		http.StatusOK,
 		"url_shorten_summary.html",
@@ -86,6 +233,7 @@ func shorturl(c *gin.Context) {
 			"short_url":    shortURL,
 			"domain":       domain,
 			"littleu_link": littleuLink,
+			"existing":     existing,
 		},
 	)
 }
@@ -113,11 +261,18 @@ func changeLink(c *gin.Context) {
 		URL: url.NewURL,
 	}
 
-	_, err := (*urlDAO).update(URLID, oldURL, newURL)
+	newID, err := (*urlDAO).update(URLID, oldURL, newURL)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
 	}
 
+	dispatchLinkEvent(newID, eventLinkChanged, LinkEventData{
+		ShortURL: url.NewURL,
+		OldShort: url.ShortURL,
+	})
+
 	c.HTML(
 		http.StatusOK,
 		"littleu_linkchanged.html",
@@ -129,8 +284,64 @@ func changeLink(c *gin.Context) {
 	)
 }
 
+func deleteLink(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	shortURL := c.PostForm("url")
+	id := shortURLToID(shortURL, chars)
+
+	owned, err := userOwnsURL(&userFound, id)
+	if err != nil || !owned {
+		c.HTML(
+			http.StatusNotFound,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": fmt.Sprintf(`Link %s not found`, shortURL),
+			},
+		)
+
+		return
+	}
+
+	owner, _ := (*urlDAO).ownerOf(id)
+
+	if err := (*urlDAO).delete(id); err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	dispatchEvent(owner, eventLinkDeleted, LinkEventData{ShortURL: shortURL})
+
+	c.Redirect(http.StatusSeeOther, "/stats")
+}
+
+// expired tells whether the link had an expiry date and it has passed.
+func (u URL) expired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
 func redirectShortURL(c *gin.Context) {
-	shortURLParam := c.Param("url")
+	url, destination, ok := linkDestination(c, c.Param("url"))
+	if !ok {
+		return
+	}
+
+	destination = withQuery(destination, url, c.Request.URL.Query())
+	c.Set(clickedContextKey, true)
+	c.Set(ownerContextKey, url.Owner)
+
+	// Browsers cache permanent redirects, every link is a temporary one so later clicks still
+	// come through littleu, get counted and follow changes to the link.
+	c.Redirect(http.StatusFound, destination)
+}
+
+// linkDestination looks up a short link and returns it along with its final destination. When
+// the link cannot be followed (unknown, not live yet, expired, looping or flagged) the matching page is
+// rendered and the last value is false.
+func linkDestination(c *gin.Context, shortURLParam string) (URL, string, bool) {
 	id := shortURLToID(shortURLParam, chars)
 
 	urlFromDB, err := (*urlDAO).findByID(id)
@@ -143,9 +354,68 @@ func redirectShortURL(c *gin.Context) {
 				"error_description": fmt.Sprintf(`Error redirecting to: %s`, shortURLParam),
 			},
 		)
-	} else {
-		c.Redirect(http.StatusMovedPermanently, urlFromDB.URL)
+
+		return URL{}, "", false
+	}
+
+	if !urlFromDB.active(time.Now()) {
+		renderNotYetAvailable(c, shortURLParam)
+
+		return URL{}, "", false
+	}
+
+	if urlFromDB.expired(time.Now()) {
+		c.HTML(
+			http.StatusGone,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": fmt.Sprintf(`The link %s has expired`, shortURLParam),
+			},
+		)
+
+		return URL{}, "", false
+	}
+
+	// The schedule replaces the destination of the link, rules and variants still come first.
+	urlFromDB.URL = urlFromDB.scheduledDestination(time.Now())
+
+	// The first redirect rule matching the device of the visitor picks the destination.
+	target, rule := urlFromDB.destinationFor(requestVisitor(c.Request))
+	c.Set(ruleContextKey, rule)
+
+	// Variants split the visitors not sent elsewhere by a rule.
+	if rule == "" {
+		if variant, ok := chooseVariant(c, shortURLParam, urlFromDB); ok {
+			target = variant.Destination
+			c.Set(variantContextKey, variant.Name)
+		}
 	}
+
+	// Links created before loop detection, or renamed with changeLink, may still point back at us.
+	destination, err := resolveShortLinkChain(target, c.Request.Host, id)
+	if err != nil {
+		c.HTML(
+			http.StatusLoopDetected,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": fmt.Sprintf(`Error redirecting to: %s, %v`, shortURLParam, err),
+			},
+		)
+
+		return URL{}, "", false
+	}
+
+	// Links disabled by an administrator stay disabled even if their entry is later removed
+	// from the files, links matching new entries are caught before the next refresh.
+	if _, blocked := blocklist.match(destination); blocked || urlFromDB.Disabled {
+		renderFlaggedLink(c, shortURLParam, destination)
+
+		return URL{}, "", false
+	}
+
+	return urlFromDB, destination, true
 }
 
 func login(config *viper.Viper) gin.HandlerFunc {
@@ -345,9 +615,10 @@ func logout(config *viper.Viper) gin.HandlerFunc {
 
 }
 
-// Render one of HTML, JSON or CSV based on the 'Accept' header of the request
+// Render one of HTML, JSON or XML based on the 'Accept' header of the request
 // If the header doesn't specify this, HTML is rendered, provided that
-// the template name is present.
+// the template name is present. CSV is not rendered here, handlers that offer it
+// (e.g. the stats exports) stream it themselves.
 func render(c *gin.Context, data gin.H, templateName string) {
 	loggedInInterface, _ := c.Get("is_logged_in")
 	data["is_logged_in"] = loggedInInterface.(bool)
@@ -492,10 +763,19 @@ func viewURLs(c *gin.Context) {
 		return
 	}
 
-	urls, err := (*urlDAO).findAllByUser(&userFound)
+	query, err := linkQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	page, err := queryLinks(&userFound, query)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
 	}
 
-	c.JSON(http.StatusOK, urls)
+	c.JSON(http.StatusOK, page)
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long in-flight requests and background work get to finish once
+// a shutdown starts, connections are closed after it either way.
+const DefaultShutdownTimeout = 15 * time.Second
+
+type connectionCloser struct {
+	name  string
+	close func() error
+}
+
+var (
+	closersMu sync.Mutex
+	// closers are the storage connections opened at start up, closed in reverse order.
+	closers []connectionCloser
+
+	// backgroundTasks are the webhook deliveries and click events still being dispatched.
+	backgroundTasks sync.WaitGroup
+
+	// shuttingDown is closed when the server stops accepting requests, long lived responses
+	// such as the live click streams end with it.
+	shuttingDown = make(chan struct{})
+)
+
+// registerCloser adds a connection to close on shutdown.
+func registerCloser(name string, close func() error) {
+	closersMu.Lock()
+	defer closersMu.Unlock()
+
+	closers = append(closers, connectionCloser{name: name, close: close})
+}
+
+// runInBackground runs fn in a goroutine the shutdown waits for.
+func runInBackground(fn func()) {
+	backgroundTasks.Add(1)
+
+	go func() {
+		defer backgroundTasks.Done()
+
+		fn()
+	}()
+}
+
+// waitWithContext waits for wg until ctx is done and reports whether wg finished.
+func waitWithContext(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shutdown drains the in-flight requests, lets the queued metadata fetches and the pending click
+// events finish within timeout and then closes every connection.
+func shutdown(server *http.Server, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error draining requests: %v", err)
+	}
+
+	if !metadataFetcher.stop(shutdownCtx) {
+		log.Printf("metadata fetches still running after %s, abandoning them", timeout)
+	}
+
+	if !waitWithContext(shutdownCtx, &backgroundTasks) {
+		log.Printf("events still being dispatched after %s, abandoning them", timeout)
+	}
+
+	closeConnections()
+}
+
+func closeConnections() {
+	closersMu.Lock()
+	defer closersMu.Unlock()
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].close(); err != nil {
+			log.Printf("error closing %s: %v", closers[i].name, err)
+		}
+	}
+
+	closers = nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"github.com/Showmax/go-fqdn"
 	"github.com/spf13/viper"
+	"log"
 	"net"
+	"strconv"
+	"time"
 
 	"net/http"
 
@@ -29,7 +33,21 @@ func showStatsPage(config *viper.Viper) gin.HandlerFunc {
 			return
 		}
 
-		urlStats, err := (*urlDAO).findAllByUser(&userFound)
+		query, err := linkQuery(c)
+		if err != nil {
+			c.HTML(
+				http.StatusBadRequest,
+				"error5xx.html",
+				gin.H{
+					"title":             "Error",
+					"error_description": err.Error(),
+				},
+			)
+
+			return
+		}
+
+		page, err := queryLinks(&userFound, query)
 		if err != nil {
 			c.HTML(
 				http.StatusInternalServerError,
@@ -50,7 +68,42 @@ func showStatsPage(config *viper.Viper) gin.HandlerFunc {
 
 		domain := net.JoinHostPort(fqdnHostName, config.GetString("port"))
 
-		urlsFull := urlsToFullStat(&urlStats)
+		filter, err := statsFilter(c)
+		if err != nil {
+			c.HTML(
+				http.StatusBadRequest,
+				"error5xx.html",
+				gin.H{
+					"title":             "Error",
+					"error_description": err.Error(),
+				},
+			)
+
+			return
+		}
+
+		tags, folders, err := (*urlDAO).findTagsAndFolders(&userFound)
+		if err != nil {
+			log.Printf("error getting tags: %v", err)
+		}
+
+		urlsFull := page.Links
+		ids := make([]int, len(urlsFull))
+
+		for i := range urlsFull {
+			ids[i] = shortURLToID(urlsFull[i].ShortURL, chars)
+		}
+
+		// Without counts the links keep the clicks counted on redirect, which ignore the filter.
+		counts, err := (*statsDAO).countByShortIDs(ids, filter)
+		if err != nil {
+			log.Printf("error counting clicks: %v", err)
+		} else {
+			for i := range urlsFull {
+				urlsFull[i].Clicks = counts[ids[i]].Total
+				urlsFull[i].VariantClicks = counts[ids[i]].Variants
+			}
+		}
 
 		c.HTML(
 			http.StatusOK,
@@ -59,11 +112,32 @@ func showStatsPage(config *viper.Viper) gin.HandlerFunc {
 				"title": "URL Stats",
 				"domain": domain,
 				"urls":  urlsFull,
+				"include_bots": filter.IncludeBots,
+				"filter":       linkFilter(c),
+				"tags":         tags,
+				"folders":      folders,
+				"query":        query,
+				"next_cursor":  page.NextCursor,
 			},
 		)
 	}
 }
 
+const (
+	// sourceParam is the query parameter tagging where a click comes from, e.g. /u/abc?src=qr.
+	sourceParam = "src"
+
+	// ruleContextKey holds the label of the redirect rule matched by redirectShortURL.
+	ruleContextKey = "redirect_rule"
+
+	// ownerContextKey holds the userKey of the owner of the link redirectShortURL followed.
+	ownerContextKey = "link_owner"
+
+	// clickedContextKey is set by redirectShortURL when the visitor is redirected, unknown,
+	// expired, looping or flagged links are not clicks.
+	clickedContextKey = "link_clicked"
+)
+
 func urlStats() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -81,21 +155,97 @@ func urlStats() gin.HandlerFunc {
 			return
 		}
 
-		session := sessions.Default(c)
-		user := session.Get("user_logged_in")
+		// The click is recorded once redirectShortURL has picked the destination, so the matched
+		// redirect rule is known.
+		c.Next()
 
-		if user == nil {
-			c.JSON(http.StatusNotFound, gin.H{"message": "unauthorized"})
-			c.Abort()
+		if !c.GetBool(clickedContextKey) {
+			return
 		}
 
-		headers := map[string][]string(c.Request.Header)
+		// Clicks are kept under the owner of the link, whether the visitor is logged in or not.
+		owner := c.GetString(ownerContextKey)
+
 		// As of now, all the headers are being saved, we might want to consider to save only a few, such as:
 		// Referrer, User-Agent, etc
-		(*statsDAO).save(shortURLParam, &headers, &user)
+		click := ClickInfo{
+			ShortURL: shortURLParam,
+			Headers:  map[string][]string(c.Request.Header),
+			Bot:      botDetector.isBot(c.Request),
+			Location: geoIP.locate(geoIP.clientIP(c.Request)),
+			Source:   c.Query(sourceParam),
+			Rule:     c.GetString(ruleContextKey),
+			Variant:  c.GetString(variantContextKey),
+		}
+
+		if _, err := (*statsDAO).save(&click, owner); err != nil {
+			log.Printf("error saving stats for %s: %v", shortURLParam, err)
+
+			return
+		}
+
+		publishClick(&click, owner)
+
+		// Bot traffic is kept out of webhooks and of the link click counters, link previews would
+		// flood the receivers.
+		if !click.Bot {
+			if err := (*urlDAO).recordClick(shortURLToID(shortURLParam, chars), time.Now()); err != nil &&
+				!errors.Is(err, errNOURLFound) {
+				log.Printf("error recording click for %s: %v", shortURLParam, err)
+			}
+
+			runInBackground(func() {
+				dispatchEvent(owner, eventLinkClicked, LinkEventData{ShortURL: shortURLParam})
+			})
+		}
 	}
 }
 
+// statsFilter builds a StatsFilter out of the include_bots, from and to query parameters. Bot
+// traffic is excluded unless include_bots=true, dates are either RFC 3339 timestamps or plain
+// days (2006-01-02), in which case "to" includes the whole day.
+func statsFilter(c *gin.Context) (StatsFilter, error) {
+	var filter StatsFilter
+
+	include, err := strconv.ParseBool(c.DefaultQuery("include_bots", "false"))
+	if err == nil {
+		filter.IncludeBots = include
+	}
+
+	if from := c.Query("from"); from != "" {
+		filter.From, err = parseStatsDate(from, false)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	if to := c.Query("to"); to != "" {
+		filter.To, err = parseStatsDate(to, true)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+func parseStatsDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errorInvalidDate(value)
+	}
+
+	if endOfDay {
+		return day.AddDate(0, 0, 1), nil
+	}
+
+	return day, nil
+}
+
 func viewStats(c *gin.Context) {
 	session := sessions.Default(c)
 	userFound := session.Get("user_logged_in")
@@ -113,10 +263,93 @@ func viewStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := (*statsDAO).findAllByUser(&userFound)
+	filter, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+
+		return
+	}
+
+	switch c.Request.Header.Get("Accept") {
+	case csvContentType, ndjsonContentType:
+		exportClicks(c, c.Request.Header.Get("Accept"), "littleu-stats", func(fn func(Click) error) error {
+			return (*statsDAO).streamByUser(&userFound, filter, fn)
+		})
+
+		return
+	}
+
+	stats, err := (*statsDAO).findAllByUser(&userFound, filter)
 	if err != nil {
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
 	}
 
 	c.JSON(http.StatusOK, stats)
-}
\ No newline at end of file
+}
+
+// viewStatsByLocation reports the number of clicks on the user's links per country and per city.
+func viewStatsByLocation(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	filter, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+
+		return
+	}
+
+	stats, err := (*statsDAO).findAllByUser(&userFound, filter)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	countries := map[string]int{}
+	cities := map[string]int{}
+
+	for _, click := range stats {
+		country := click.Country
+		if country == "" {
+			country = "unknown"
+		}
+
+		countries[country]++
+
+		if click.City != "" {
+			cities[country+"/"+click.City]++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"countries": countries,
+		"cities":    cities,
+	})
+}
+
+// maxStatsTime stands for an open upper bound in StatsFilter queries.
+var maxStatsTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// bounds returns the filter range with an open upper bound replaced by maxStatsTime.
+func (f StatsFilter) bounds() (time.Time, time.Time) {
+	if f.To.IsZero() {
+		return f.From, maxStatsTime
+	}
+
+	return f.From, f.To
+}
+
+func (f StatsFilter) matches(createdAt time.Time, bot bool) bool {
+	from, to := f.bounds()
+
+	return (f.IncludeBots || !bot) && !createdAt.Before(from) && createdAt.Before(to)
+}
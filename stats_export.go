@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+
+	// exportFlushEvery is the number of rows written before the response is flushed to the client.
+	exportFlushEvery = 500
+)
+
+var clickCSVHeader = []string{
+	"created_at", "short_url", "bot", "country", "region", "city", "referrer", "user_agent", "source", "rule",
+	"variant",
+}
+
+func clickCSVRecord(click *Click) []string {
+	return []string{
+		click.CreatedAt.UTC().Format(time.RFC3339),
+		click.ShortURL,
+		strconv.FormatBool(click.Bot),
+		click.Country,
+		click.Region,
+		click.City,
+		click.Referrer,
+		click.UserAgent,
+		click.Source,
+		click.Rule,
+		click.Variant,
+	}
+}
+
+// exportFormat picks the export format from the "format" query parameter, falling back to
+// the Accept header and then to CSV.
+func exportFormat(c *gin.Context) string {
+	switch c.Query("format") {
+	case "csv":
+		return csvContentType
+	case "ndjson", "json":
+		return ndjsonContentType
+	}
+
+	if c.Request.Header.Get("Accept") == ndjsonContentType {
+		return ndjsonContentType
+	}
+
+	return csvContentType
+}
+
+// exportClicks writes the clicks produced by stream as CSV or NDJSON, row by row, so the
+// whole export is never held in memory.
+func exportClicks(c *gin.Context, contentType, filename string, stream func(fn func(Click) error) error) {
+	extension := "csv"
+	if contentType == ndjsonContentType {
+		extension = "ndjson"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, extension))
+	c.Status(http.StatusOK)
+
+	rows := 0
+
+	var err error
+
+	if contentType == ndjsonContentType {
+		encoder := json.NewEncoder(c.Writer)
+
+		err = stream(func(click Click) error {
+			if err := encoder.Encode(click); err != nil {
+				return err
+			}
+
+			if rows++; rows%exportFlushEvery == 0 {
+				c.Writer.Flush()
+			}
+
+			return nil
+		})
+	} else {
+		writer := csv.NewWriter(c.Writer)
+		_ = writer.Write(clickCSVHeader)
+
+		err = stream(func(click Click) error {
+			if err := writer.Write(clickCSVRecord(&click)); err != nil {
+				return err
+			}
+
+			if rows++; rows%exportFlushEvery == 0 {
+				writer.Flush()
+				c.Writer.Flush()
+			}
+
+			return writer.Error()
+		})
+
+		writer.Flush()
+	}
+
+	// Headers are already sent at this point, the best we can do is to log and cut the body short.
+	if err != nil {
+		log.Printf("error exporting stats: %v", err)
+	}
+}
+
+// exportAccountStats streams every click on the links of the logged in user.
+func exportAccountStats(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	filter, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+
+		return
+	}
+
+	exportClicks(c, exportFormat(c), "littleu-stats", func(fn func(Click) error) error {
+		return (*statsDAO).streamByUser(&userFound, filter, fn)
+	})
+}
+
+// exportLinkStats streams the clicks of a single link owned by the logged in user.
+func exportLinkStats(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	filter, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+
+		return
+	}
+
+	shortURL := c.Param("url")
+	shortID := shortURLToID(shortURL, chars)
+
+	owned, err := userOwnsURL(&userFound, shortID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"message": errorURLNotFound(shortID).Error()})
+
+		return
+	}
+
+	exportClicks(c, exportFormat(c), "littleu-stats-"+shortURL, func(fn func(Click) error) error {
+		return (*statsDAO).streamByShortID(shortID, filter, fn)
+	})
+}
+
+// userOwnsURL tells whether the link belongs to user, unknown links belong to nobody.
+func userOwnsURL(user *interface{}, shortID int) (bool, error) {
+	key, ok := userKey(user)
+	if !ok {
+		return false, nil
+	}
+
+	owner, err := (*urlDAO).ownerOf(shortID)
+	if err != nil {
+		if errors.Is(err, errNOURLFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return owner == key, nil
+}
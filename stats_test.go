@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestURLStatsRecordsRedirectsOnly makes sure only visitors who were redirected count as clicks,
+// links that could not be followed are not.
+func TestURLStatsRecordsRedirectsOnly(t *testing.T) {
+	useMemoryEngine(t)
+
+	botDetector, _ = newBotDetector(nil)
+	geoIP = &GeoIPResolver{}
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.LoadHTMLGlob("templates/*")
+	router.GET("/u/:url", urlStats(), redirectShortURL)
+
+	user := addTestUser(t, "clicker")
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		url    URL
+		status int
+		clicks int
+	}{
+		{name: "redirected", url: URL{URL: "https://example.org/"}, status: http.StatusFound, clicks: 1},
+		{name: "expired", url: URL{URL: "https://example.org/", ExpiresAt: &past}, status: http.StatusGone},
+		{name: "not live yet", url: URL{URL: "https://example.org/", ActiveFrom: &future}},
+		{name: "disabled", url: URL{URL: "https://example.org/"}, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := (*urlDAO).save(tt.url, &user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.status == http.StatusForbidden {
+				if err := (*urlDAO).disable(id); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/u/"+idToShortURL(id, chars), nil)
+			req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if tt.status != 0 && w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			clicks, err := (*statsDAO).findByShortID(id, StatsFilter{IncludeBots: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			url, err := (*urlDAO).findByID(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(clicks) != tt.clicks || url.Clicks != tt.clicks {
+				t.Fatalf("%d clicks saved and %d counted, want %d", len(clicks), url.Clicks, tt.clicks)
+			}
+		})
+	}
+
+	// The click is kept under the owner of the link, taken from the link the redirect resolved.
+	owned, err := (*statsDAO).findAllByUser(&user, StatsFilter{IncludeBots: true})
+	if err != nil || len(owned) != 1 {
+		t.Fatalf("findAllByUser() = %d clicks, %v, want 1", len(owned), err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/u/zzzzzz", nil))
+
+	if w.Code == http.StatusFound {
+		t.Fatal("an unknown link was redirected")
+	}
+}
+
+func TestCountByShortIDs(t *testing.T) {
+	useMemoryEngine(t)
+
+	clicks := []ClickInfo{
+		{ShortURL: idToShortURL(1, chars)},
+		{ShortURL: idToShortURL(1, chars), Variant: "a"},
+		{ShortURL: idToShortURL(1, chars), Variant: "a", Bot: true},
+		{ShortURL: idToShortURL(2, chars), Variant: "b"},
+		{ShortURL: idToShortURL(3, chars)},
+	}
+
+	for i := range clicks {
+		if _, err := (*statsDAO).save(&clicks[i], "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter StatsFilter
+		want   map[int]ClickCount
+	}{
+		{
+			name: "humans",
+			want: map[int]ClickCount{
+				1: {Total: 2, Variants: map[string]int{"a": 1}},
+				2: {Total: 1, Variants: map[string]int{"b": 1}},
+				4: {},
+			},
+		},
+		{
+			name:   "with bots",
+			filter: StatsFilter{IncludeBots: true},
+			want: map[int]ClickCount{
+				1: {Total: 3, Variants: map[string]int{"a": 2}},
+				2: {Total: 1, Variants: map[string]int{"b": 1}},
+				4: {},
+			},
+		},
+		{
+			name:   "out of range",
+			filter: StatsFilter{From: time.Now().Add(time.Hour)},
+			want:   map[int]ClickCount{1: {}, 2: {}, 4: {}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (*statsDAO).countByShortIDs([]int{1, 2, 4}, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("countByShortIDs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,7 +8,83 @@ import (
 
 // URL ...
 type URL struct {
-	URL string `form:"url"`
+	URL string `form:"url" json:"url"`
+	// Alias is the short code asked for when the link is created, one is generated when empty.
+	// It is not stored, the short code of a link is its ID.
+	Alias     string     `form:"alias" json:"alias,omitempty"`
+	Tags      []string   `form:"-" json:"tags,omitempty"`
+	Folder    string     `form:"folder" json:"folder,omitempty"`
+	ExpiresAt *time.Time `form:"-" json:"expires_at,omitempty"`
+	// ActiveFrom is when the link goes live, it is not redirected before.
+	ActiveFrom *time.Time `form:"-" json:"active_from,omitempty"`
+	// Schedule changes the destination of the link over time, its entries are sorted by From.
+	Schedule []ScheduledDestination `form:"-" json:"schedule,omitempty"`
+	// Disabled links are not redirected, see the blocklist.
+	Disabled bool `form:"-" json:"disabled,omitempty"`
+	// CreatedAt, Clicks and LastClickAt are kept by the engines, they are ignored when saving.
+	CreatedAt   time.Time  `form:"-" json:"created_at"`
+	Clicks      int        `form:"-" json:"clicks"`
+	LastClickAt *time.Time `form:"-" json:"last_click_at,omitempty"`
+	// LinkMetadata is fetched from the destination in the background, see MetadataFetcher.
+	LinkMetadata `form:"-"`
+	// Rules send some visitors somewhere else than URL, the first matching rule wins.
+	Rules []RedirectRule `form:"-" json:"rules,omitempty"`
+	// Variants, when there are any, split the visitors not matching a rule between their
+	// destinations. StickyVariants keeps a visitor on the same variant with a cookie.
+	Variants       []Variant `form:"-" json:"variants,omitempty"`
+	StickyVariants bool      `form:"-" json:"sticky_variants,omitempty"`
+	// UTM parameters are added to the destination when it does not have them already.
+	UTM UTMParams `json:"utm"`
+	// QueryPolicy says what to do with the query parameters of the visitor, see queryPolicies.
+	// Empty means the QUERY_PASSTHROUGH default.
+	QueryPolicy string `form:"query_policy" json:"query_policy,omitempty"`
+	// Owner is the userKey of the user the link belongs to, it is filled in by URLDao.findByID and
+	// ignored when saving.
+	Owner string `form:"-" json:"-"`
+}
+
+// UTMParams are the default campaign parameters of a link.
+type UTMParams struct {
+	Source   string `form:"utm_source" json:"source,omitempty" bson:"source,omitempty"`
+	Medium   string `form:"utm_medium" json:"medium,omitempty" bson:"medium,omitempty"`
+	Campaign string `form:"utm_campaign" json:"campaign,omitempty" bson:"campaign,omitempty"`
+	Term     string `form:"utm_term" json:"term,omitempty" bson:"term,omitempty"`
+	Content  string `form:"utm_content" json:"content,omitempty" bson:"content,omitempty"`
+}
+
+// ScheduledDestination replaces the destination of a link from the time From on, until the next
+// entry of the schedule starts.
+type ScheduledDestination struct {
+	From        time.Time `json:"from" bson:"from"`
+	Destination string    `json:"destination" bson:"destination"`
+}
+
+// Variant is one of the weighted destinations of an A/B split, it gets Weight out of the sum of
+// the weights of the link variants.
+type Variant struct {
+	Name        string `json:"name" bson:"name"`
+	Destination string `json:"destination" bson:"destination"`
+	Weight      int    `json:"weight" bson:"weight"`
+}
+
+// RedirectRule sends the visitors matching all of its non empty conditions to Destination.
+// Country is an ISO 3166 code, Language matches the preferred Accept-Language of the visitor
+// ("pt" matches "pt-BR").
+type RedirectRule struct {
+	OS          string `json:"os,omitempty" bson:"os,omitempty"`
+	Device      string `json:"device,omitempty" bson:"device,omitempty"`
+	Country     string `json:"country,omitempty" bson:"country,omitempty"`
+	Language    string `json:"language,omitempty" bson:"language,omitempty"`
+	Destination string `json:"destination" bson:"destination"`
+}
+
+// LinkMetadata describes the destination page of a link: its title, description, favicon and
+// Open Graph image. Favicon and Image are absolute URLs.
+type LinkMetadata struct {
+	Title       string `json:"title,omitempty" bson:"title,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	Favicon     string `json:"favicon,omitempty" bson:"favicon,omitempty"`
+	Image       string `json:"image,omitempty" bson:"image,omitempty"`
 }
 
 // URLDocument ...
@@ -19,6 +95,25 @@ type URLDocument struct {
 	ShortID   int                `bson:"shortid"`
 	URL       string             `bson:"url"`
 	UserID    primitive.ObjectID `bson:"user_id"`
+	Tags      []string           `bson:"tags,omitempty"`
+	Folder    string             `bson:"folder"`
+	ExpiresAt *time.Time         `bson:"expires_at"`
+	Disabled  bool               `bson:"disabled"`
+
+	ActiveFrom *time.Time             `bson:"active_from"`
+	Schedule   []ScheduledDestination `bson:"schedule,omitempty"`
+	// Clicks and LastClickAt are always stored so the keyset pagination of query can compare them.
+	Clicks      int       `bson:"clicks"`
+	LastClickAt time.Time `bson:"last_click_at"`
+
+	LinkMetadata `bson:",inline"`
+	Rules        []RedirectRule `bson:"rules,omitempty"`
+
+	Variants       []Variant `bson:"variants,omitempty"`
+	StickyVariants bool      `bson:"sticky_variants"`
+
+	UTM         UTMParams `bson:"utm"`
+	QueryPolicy string    `bson:"query_policy"`
 }
 
 // URLChange ...
@@ -31,13 +126,31 @@ type URLChange struct {
 type URLStat struct {
 	ShortID int     `json:"id"`
 	Url     string	`json:"url"`
+	Tags    []string `json:"tags"`
+	Folder  string   `json:"folder"`
+
+	CreatedAt   time.Time  `json:"created_at"`
+	Clicks      int        `json:"clicks"`
+	LastClickAt *time.Time `json:"last_click_at,omitempty"`
+
+	LinkMetadata
 }
 
 // URLStatFull is basically a URLStat but instead of the short ID, it has the short URL corresponding
 // to the short ID value.
 type URLStatFull struct {
 	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	OriginalURL string   `json:"original_url"`
+	Clicks      int      `json:"clicks"`
+	Tags        []string `json:"tags"`
+	Folder      string   `json:"folder"`
+
+	CreatedAt   time.Time  `json:"created_at"`
+	LastClickAt *time.Time `json:"last_click_at,omitempty"`
+	// VariantClicks counts the clicks per variant, only filled in by the stats page.
+	VariantClicks map[string]int `json:"variant_clicks,omitempty"`
+
+	LinkMetadata
 }
 
 // UserMongo ...
@@ -67,13 +180,146 @@ type UserInMemory struct {
 	Password  string
 }
 
+// ClickInfo is what the stats pipeline knows about a single click when it is recorded.
+type ClickInfo struct {
+	ShortURL string
+	Headers  map[string][]string
+	Bot      bool
+	Location GeoLocation
+	Source   string
+	// Rule is the label of the redirect rule the click matched, empty for the link destination.
+	Rule string
+	// Variant is the name of the variant the visitor was sent to, if the link has any.
+	Variant string
+}
+
+// Click is a single recorded visit to a short link, as returned by StatsDAO.
+type Click struct {
+	CreatedAt time.Time `json:"created_at"`
+	ShortURL  string    `json:"short_url"`
+	Bot       bool      `json:"bot"`
+	Country   string    `json:"country"`
+	Region    string    `json:"region"`
+	City      string    `json:"city"`
+	Referrer  string    `json:"referrer"`
+	UserAgent string    `json:"user_agent"`
+	Source    string    `json:"source"`
+	Rule      string    `json:"rule,omitempty"`
+	Variant   string    `json:"variant,omitempty"`
+}
+
+// ClickCount is the number of clicks on a link and how many of them went to each variant.
+type ClickCount struct {
+	Total    int
+	Variants map[string]int
+}
+
+// add counts n clicks on variant, the empty variant only adds to the total.
+func (count *ClickCount) add(variant string, n int) {
+	count.Total += n
+
+	if variant == "" {
+		return
+	}
+
+	if count.Variants == nil {
+		count.Variants = map[string]int{}
+	}
+
+	count.Variants[variant] += n
+}
+
+// StatsFilter narrows down the clicks returned by StatsDAO, zero From/To values mean the range
+// is unbounded on that side. To is exclusive.
+type StatsFilter struct {
+	IncludeBots bool
+	From        time.Time
+	To          time.Time
+}
+
+// LinkFilter narrows down the links returned by URLDao.findAllByUser, empty fields match every link.
+type LinkFilter struct {
+	Tag    string
+	Folder string
+}
+
+// LinkQuery asks URLDao.query for a page of links, sorted by Sort (see linkSorts) and then by short
+// ID. Search matches the short code exactly or the destination or title in part.
+type LinkQuery struct {
+	LinkFilter
+	Search string
+	Sort   string
+	Asc    bool
+	// After is the last link of the previous page, nil for the first page.
+	After *LinkCursor
+	Limit int
+}
+
+// LinkCursor is the position of a link in a LinkQuery order, Value is the sort key of the link
+// (times as Unix nanoseconds, 0 for no time).
+type LinkCursor struct {
+	Value   int64 `json:"v"`
+	ShortID int   `json:"id"`
+}
+
+// LinkPage is a page of links, NextCursor is empty on the last one.
+type LinkPage struct {
+	Links      []URLStatFull `json:"links"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// LinkEdit is a change applied to several links at once, a nil Folder leaves folders as they are.
+type LinkEdit struct {
+	AddTags    []string
+	RemoveTags []string
+	Folder     *string
+}
+
+// Webhook is a user's subscription to link events, delivered to URL and signed with Secret.
+type Webhook struct {
+	ID        string    `json:"id" bson:"_id"`
+	UserKey   string    `json:"-" bson:"user_key"`
+	URL       string    `json:"url" bson:"url"`
+	Secret    string    `json:"-" bson:"secret"`
+	Events    []string  `json:"events" bson:"events"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// BlocklistEntry is a blocked domain, wildcard domain or URL added by an administrator, entries
+// loaded from the blocklist files are not stored.
+type BlocklistEntry struct {
+	ID        string    `json:"id" bson:"_id"`
+	Entry     string    `json:"entry" bson:"entry"`
+	AddedBy   string    `json:"added_by" bson:"added_by"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// WebhookDelivery is one attempt at delivering an event to a webhook.
+type WebhookDelivery struct {
+	ID         string    `json:"id" bson:"_id"`
+	WebhookID  string    `json:"webhook_id" bson:"webhook_id"`
+	EventID    string    `json:"event_id" bson:"event_id"`
+	Event      string    `json:"event" bson:"event"`
+	Attempt    int       `json:"attempt" bson:"attempt"`
+	StatusCode int       `json:"status_code" bson:"status_code"`
+	Error      string    `json:"error,omitempty" bson:"error"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
 // StatsMongo ...
 type StatsMongo struct {
 	ID			primitive.ObjectID	`bson:"_id"`
 	CreatedAt	time.Time          	`bson:"created_at"`
 	ShortID		int					`bson:"shortid"`
-	UserID		int					`bson:"user_id"`
+	UserID		primitive.ObjectID	`bson:"user_id"`
 	Headers		map[string][]string `bson:"req_info"`
+	Bot			bool				`bson:"bot"`
+	Country		string				`bson:"country"`
+	Region		string				`bson:"region"`
+	City		string				`bson:"city"`
+	Source		string				`bson:"source"`
+	Rule		string				`bson:"rule,omitempty"`
+	Variant		string				`bson:"variant,omitempty"`
 }
 
 // StatsPostgresql ...
@@ -82,6 +328,13 @@ type StatsPostgresql struct {
 	CreatedAt 	time.Time
 	ShortID   	int
 	UserID		int
+	Bot			bool
+	Country		string
+	Region		string
+	City		string
+	Source		string
+	Rule		string
+	Variant		string
 }
 
 // StatsInMemory ...
@@ -90,6 +343,13 @@ type StatsInMemory struct {
 	ShortID		int
 	UserID 		int
 	Headers		map[string][]string
+	Bot			bool
+	Country		string
+	Region		string
+	City		string
+	Source		string
+	Rule		string
+	Variant		string
 }
 
 // StatsHeadersPostgresql ...
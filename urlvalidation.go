@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/idna"
+)
+
+const (
+	// DefaultMaxURLLength ...
+	DefaultMaxURLLength = 2048
+
+	trailingSlashKeep  = "keep"
+	trailingSlashAdd   = "add"
+	trailingSlashStrip = "strip"
+)
+
+var (
+	// schemePrefix matches an explicit scheme such as "javascript:" or "https:", but not "host:8080".
+	schemePrefix = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):[^0-9]`)
+	// authorityPrefix matches a scheme followed by an authority, "://" further in the URL, e.g.
+	// in the query, does not count.
+	authorityPrefix = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URLPolicy decides which destinations can be shortened and how they are normalized.
+type URLPolicy struct {
+	AllowedSchemes map[string]bool
+	MaxLength      int
+	TrailingSlash  string
+}
+
+func newURLPolicy(config *viper.Viper) *URLPolicy {
+	policy := &URLPolicy{
+		AllowedSchemes: map[string]bool{},
+		MaxLength:      config.GetInt("MAX_URL_LENGTH"),
+		TrailingSlash:  strings.ToLower(config.GetString("TRAILING_SLASH")),
+	}
+
+	for _, scheme := range strings.Split(config.GetString("ALLOWED_SCHEMES"), ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			policy.AllowedSchemes[scheme] = true
+		}
+	}
+
+	if len(policy.AllowedSchemes) == 0 {
+		policy.AllowedSchemes = map[string]bool{"http": true, "https": true}
+	}
+
+	if policy.MaxLength <= 0 {
+		policy.MaxLength = DefaultMaxURLLength
+	}
+
+	switch policy.TrailingSlash {
+	case trailingSlashAdd, trailingSlashStrip:
+	default:
+		policy.TrailingSlash = trailingSlashKeep
+	}
+
+	return policy
+}
+
+// normalize validates a destination URL and returns its canonical form: lowercase scheme and
+// host, punycode host, no default port, "/" as the empty path and the configured trailing
+// slash policy applied. URLs without a scheme are taken as http.
+func (p *URLPolicy) normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errorInvalidDestination("the url cannot be empty")
+	}
+
+	if len(raw) > p.MaxLength {
+		return "", errorInvalidDestination("the url is too long")
+	}
+
+	if !authorityPrefix.MatchString(raw) {
+		if match := schemePrefix.FindStringSubmatch(raw); match != nil {
+			return "", errorInvalidDestination(match[1] + " urls are not allowed")
+		}
+
+		raw = "http://" + strings.TrimPrefix(raw, "//")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errorInvalidDestination("the url is not valid")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !p.AllowedSchemes[u.Scheme] {
+		return "", errorInvalidDestination(u.Scheme + " urls are not allowed")
+	}
+
+	if u.User != nil {
+		return "", errorInvalidDestination("urls with credentials are not allowed")
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 literal
+		host = "[" + host + "]"
+	}
+
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	switch p.TrailingSlash {
+	case trailingSlashAdd:
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+	case trailingSlashStrip:
+		if u.Path != "/" {
+			u.Path = strings.TrimRight(u.Path, "/")
+		}
+	}
+
+	normalized := u.String()
+	if len(normalized) > p.MaxLength {
+		return "", errorInvalidDestination("the url is too long")
+	}
+
+	return normalized, nil
+}
+
+// normalizeHost lowercases the host and converts internationalized names to punycode.
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", errorInvalidDestination("the url has no host")
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.ToLower(strings.TrimSuffix(host, ".")))
+	if err != nil {
+		return "", errorInvalidDestination("the host " + host + " is not valid")
+	}
+
+	if !strings.Contains(ascii, ".") && ascii != "localhost" {
+		return "", errorInvalidDestination("the host " + host + " is not a domain name")
+	}
+
+	return ascii, nil
+}
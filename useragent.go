@@ -0,0 +1,53 @@
+package main
+
+import "strings"
+
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+
+	osIOS     = "ios"
+	osAndroid = "android"
+	osWindows = "windows"
+	osMacOS   = "macos"
+	osLinux   = "linux"
+	osOther   = "other"
+)
+
+// deviceType makes a coarse guess of the kind of device behind a User-Agent.
+func deviceType(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return deviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		return deviceMobile
+	default:
+		return deviceDesktop
+	}
+}
+
+// operatingSystem makes a coarse guess of the operating system behind a User-Agent. iPadOS
+// reports itself as macOS by default, those iPads are taken as desktops.
+func operatingSystem(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return osIOS
+	case strings.Contains(ua, "android"):
+		return osAndroid
+	case strings.Contains(ua, "windows"):
+		return osWindows
+	case strings.Contains(ua, "mac os x") || strings.Contains(ua, "macintosh"):
+		return osMacOS
+	case strings.Contains(ua, "linux") || strings.Contains(ua, "cros"):
+		return osLinux
+	default:
+		return osOther
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
@@ -45,7 +47,7 @@ func shortURLToID(shortURL string, mChars []rune) int {
 		if c >= int('a') && c <= int('z') {
 			id = id*mapCharsSize + c - int('a')
 		} else if c >= int('A') && c <= int('Z') {
-			id = id*mapCharsSize + c - int('Z') + 26
+			id = id*mapCharsSize + c - int('A') + 26
 		} else {
 			id = id*mapCharsSize + c - int('0') + 52
 		}
@@ -102,10 +104,83 @@ func urlsToFullStat(urls *[]URLStat) []URLStatFull {
 	for _, u := range *urls {
 		shortURL := idToShortURL(u.ShortID, chars)
 		urlFull = append(urlFull, URLStatFull{
-			ShortURL:    shortURL,
-			OriginalURL: u.Url,
+			ShortURL:     shortURL,
+			OriginalURL:  u.Url,
+			Clicks:       u.Clicks,
+			Tags:         u.Tags,
+			Folder:       u.Folder,
+			CreatedAt:    u.CreatedAt,
+			LastClickAt:  u.LastClickAt,
+			LinkMetadata: u.LinkMetadata,
 		})
 	}
 
 	return urlFull
 }
+
+// userKey returns an engine independent identifier for a session user, the second value is
+// false when there is no (known) user.
+func userKey(user *interface{}) (string, bool) {
+	switch u := (*user).(type) {
+	case *UserMongo:
+		return u.ID.Hex(), true
+	case *UserPostgresql:
+		return strconv.Itoa(u.ID), true
+	case *UserInMemory:
+		return strconv.FormatUint(u.ID, 10), true
+	default:
+		return "", false
+	}
+}
+
+// userName returns the username of a session user, the second value is false when there is
+// no (known) user.
+func userName(user *interface{}) (string, bool) {
+	switch u := (*user).(type) {
+	case *UserMongo:
+		return u.User, true
+	case *UserPostgresql:
+		return u.User, true
+	case *UserInMemory:
+		return u.User, true
+	default:
+		return "", false
+	}
+}
+
+// splitTags splits a comma or semicolon separated list of tags, see normalizeTags.
+func splitTags(tags string) []string {
+	return normalizeTags(strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ',' || r == ';'
+	}))
+}
+
+// normalizeTags trims the tags and drops empty and repeated ones.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.ContainsAny(tag, ",;") || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// queryPolicyMerge adds the visitor parameters the destination does not have.
+	queryPolicyMerge = "merge"
+	// queryPolicyOverride adds the visitor parameters, replacing those of the destination.
+	queryPolicyOverride = "override"
+	// queryPolicyDrop ignores the visitor parameters.
+	queryPolicyDrop = "drop"
+
+	// DefaultQueryPolicy keeps redirects as they were before query passthrough.
+	DefaultQueryPolicy = queryPolicyDrop
+
+	maxUTMLength = 200
+)
+
+var queryPolicies = map[string]bool{
+	queryPolicyMerge:    true,
+	queryPolicyOverride: true,
+	queryPolicyDrop:     true,
+}
+
+// internalQueryParams are read by littleu itself and never forwarded.
+var internalQueryParams = map[string]bool{
+	sourceParam:  true,
+	previewParam: true,
+}
+
+// defaultQueryPolicy reads QUERY_PASSTHROUGH, the policy of links without one of their own.
+func defaultQueryPolicy(config *viper.Viper) string {
+	policy := strings.ToLower(strings.TrimSpace(config.GetString("QUERY_PASSTHROUGH")))
+	if !queryPolicies[policy] {
+		return DefaultQueryPolicy
+	}
+
+	return policy
+}
+
+// values returns the non empty parameters as utm_* query parameters.
+func (p UTMParams) values() url.Values {
+	values := url.Values{}
+
+	for key, value := range map[string]string{
+		"utm_source":   p.Source,
+		"utm_medium":   p.Medium,
+		"utm_campaign": p.Campaign,
+		"utm_term":     p.Term,
+		"utm_content":  p.Content,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+
+	return values
+}
+
+func normalizeUTM(p UTMParams) (UTMParams, error) {
+	for _, value := range []*string{&p.Source, &p.Medium, &p.Campaign, &p.Term, &p.Content} {
+		if *value = strings.TrimSpace(*value); len(*value) > maxUTMLength {
+			return UTMParams{}, errorInvalidDestination("UTM parameters cannot be longer than " + strconv.Itoa(maxUTMLength))
+		}
+	}
+
+	return p, nil
+}
+
+func normalizeQueryPolicy(policy string) (string, error) {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if policy != "" && !queryPolicies[policy] {
+		return "", errorInvalidDestination("the query policy must be merge, override or drop, not " + policy)
+	}
+
+	return policy, nil
+}
+
+// withQuery returns destination with the UTM parameters of link and, as its query policy says,
+// the incoming query parameters of the visitor. The destination is returned untouched when there
+// is nothing to add.
+func withQuery(destination string, link URL, incoming url.Values) string {
+	policy := link.QueryPolicy
+	if policy == "" {
+		policy = queryPolicy
+	}
+
+	utm := link.UTM.values()
+
+	if len(utm) == 0 && (policy == queryPolicyDrop || len(incoming) == 0) {
+		return destination
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	query := parsed.Query()
+	changed := false
+
+	for key, values := range utm {
+		if _, ok := query[key]; !ok {
+			query[key] = values
+			changed = true
+		}
+	}
+
+	if policy != queryPolicyDrop {
+		for key, values := range incoming {
+			if internalQueryParams[key] {
+				continue
+			}
+
+			if _, ok := query[key]; ok && policy == queryPolicyMerge {
+				continue
+			}
+
+			query[key] = values
+			changed = true
+		}
+	}
+
+	if !changed {
+		return destination
+	}
+
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxVariants      = 10
+	maxVariantWeight = 1000
+
+	// variantCookiePrefix followed by the short code names the cookie of sticky variants.
+	variantCookiePrefix = "lu_variant_"
+	variantCookieMaxAge = 30 * 24 * 60 * 60
+
+	// variantContextKey holds the name of the variant picked by redirectShortURL.
+	variantContextKey = "redirect_variant"
+)
+
+// variantName keeps names usable as cookie values.
+var variantName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,20}$`)
+
+// variant returns the variant called name, variants with no weight are paused.
+func (u URL) variant(name string) (Variant, bool) {
+	for _, v := range u.Variants {
+		if v.Name == name && v.Weight > 0 {
+			return v, true
+		}
+	}
+
+	return Variant{}, false
+}
+
+// pickVariant picks one of the variants at random, in proportion to their weights.
+func pickVariant(variants []Variant) Variant {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+
+	var source randGenSrc
+
+	n := int(source.Uint64() % uint64(total))
+
+	for _, v := range variants {
+		if n < v.Weight {
+			return v
+		}
+
+		n -= v.Weight
+	}
+
+	return variants[len(variants)-1]
+}
+
+// chooseVariant returns the variant the visitor is sent to, the one in the cookie for links with
+// sticky variants. The cookie is only set on redirects, not on previews. The second value is
+// false when the link has no variants.
+func chooseVariant(c *gin.Context, shortURL string, u URL) (Variant, bool) {
+	if len(u.Variants) == 0 {
+		return Variant{}, false
+	}
+
+	cookieName := variantCookiePrefix + shortURL
+
+	if u.StickyVariants {
+		if name, err := c.Cookie(cookieName); err == nil {
+			if v, ok := u.variant(name); ok {
+				return v, true
+			}
+		}
+	}
+
+	v := pickVariant(u.Variants)
+
+	if u.StickyVariants && !c.GetBool(previewContextKey) {
+		c.SetCookie(cookieName, v.Name, variantCookieMaxAge, "/u/"+shortURL, "", false, true)
+	}
+
+	return v, true
+}
+
+// prepareVariants validates the variants, unnamed ones are named after their position (A, B...).
+// A split needs at least two variants, none removes it.
+func prepareVariants(variants []Variant, requestHost string) ([]Variant, error) {
+	if len(variants) == 1 {
+		return nil, errorInvalidVariant(1, "a split needs at least two variants")
+	}
+
+	if len(variants) > maxVariants {
+		return nil, errorInvalidVariant(maxVariants+1, fmt.Sprintf("no more than %d variants are allowed", maxVariants))
+	}
+
+	prepared := make([]Variant, 0, len(variants))
+	names := map[string]bool{}
+	total := 0
+
+	for i, v := range variants {
+		if v.Name = strings.TrimSpace(v.Name); v.Name == "" {
+			v.Name = string(rune('A' + i))
+		}
+
+		if !variantName.MatchString(v.Name) {
+			return nil, errorInvalidVariant(i+1, "names can only have up to 20 letters, digits, '-' or '_'")
+		}
+
+		if names[v.Name] {
+			return nil, errorInvalidVariant(i+1, "the name "+v.Name+" is repeated")
+		}
+
+		if v.Weight < 0 || v.Weight > maxVariantWeight {
+			return nil, errorInvalidVariant(i+1, "the weight must be between 0 and "+strconv.Itoa(maxVariantWeight))
+		}
+
+		destination, err := prepareDestination(v.Destination, requestHost)
+		if err != nil {
+			return nil, errorInvalidVariant(i+1, err.Error())
+		}
+
+		v.Destination = destination
+		names[v.Name] = true
+		total += v.Weight
+		prepared = append(prepared, v)
+	}
+
+	if len(prepared) > 0 && total == 0 {
+		return nil, errorInvalidVariant(1, "at least one variant needs some weight")
+	}
+
+	return prepared, nil
+}
+
+func saveLinkVariants(c *gin.Context, id int, variants []Variant, sticky bool) ([]Variant, error) {
+	prepared, err := prepareVariants(variants, c.Request.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := (*urlDAO).saveVariants(id, prepared, sticky); err != nil {
+		return nil, err
+	}
+
+	dispatchLinkEvent(id, eventLinkChanged, LinkEventData{ShortURL: c.Param("url")})
+
+	return prepared, nil
+}
+
+// variantClicks counts the clicks per variant, clicks matching a redirect rule are not part of
+// the split and are left out.
+func variantClicks(clicks []Click) map[string]int {
+	counts := map[string]int{}
+
+	for _, click := range clicks {
+		if click.Variant != "" {
+			counts[click.Variant]++
+		}
+	}
+
+	return counts
+}
+
+// viewLinkVariants returns the variants of a link.
+func viewLinkVariants(c *gin.Context) {
+	_, url, ok := ownedLink(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("link %s not found", c.Param("url"))})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"variants": url.Variants,
+		"sticky":   url.StickyVariants,
+	})
+}
+
+// updateLinkVariants replaces the variants of a link, an empty list removes the split.
+func updateLinkVariants(c *gin.Context) {
+	id, _, ok := ownedLink(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("link %s not found", c.Param("url"))})
+
+		return
+	}
+
+	var request struct {
+		Variants []Variant `json:"variants"`
+		Sticky   bool      `json:"sticky"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	variants, err := saveLinkVariants(c, id, request.Variants, request.Sticky)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"variants": variants,
+		"sticky":   request.Sticky,
+	})
+}
+
+// viewVariantStats reports the number of clicks on every variant of a link.
+func viewVariantStats(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	if userFound == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	filter, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+
+		return
+	}
+
+	shortID := shortURLToID(c.Param("url"), chars)
+
+	owned, err := userOwnsURL(&userFound, shortID)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"message": errorURLNotFound(shortID).Error()})
+
+		return
+	}
+
+	clicks, err := (*statsDAO).findByShortID(shortID, filter)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variants": variantClicks(clicks)})
+}
+
+// editVariants saves the variants of the rules page, rows without a destination are dropped.
+func editVariants(c *gin.Context) {
+	id, url, ok := ownedLink(c)
+	if !ok {
+		c.HTML(
+			http.StatusNotFound,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": fmt.Sprintf(`Link %s not found`, c.Param("url")),
+			},
+		)
+
+		return
+	}
+
+	names := c.PostFormArray("name")
+	weights := c.PostFormArray("weight")
+	destinations := c.PostFormArray("destination")
+	sticky := c.PostForm("sticky") != ""
+
+	var variants []Variant
+
+	for i, destination := range destinations {
+		if strings.TrimSpace(destination) == "" || i >= len(names) || i >= len(weights) {
+			continue
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(weights[i]))
+		if err != nil {
+			renderRulesPage(c, http.StatusUnprocessableEntity, url, gin.H{
+				"ErrorMessage": errorInvalidVariant(len(variants)+1, "the weight must be a number").Error(),
+			})
+
+			return
+		}
+
+		variants = append(variants, Variant{Name: names[i], Destination: destination, Weight: weight})
+	}
+
+	saved, err := saveLinkVariants(c, id, variants, sticky)
+	if err != nil {
+		url.Variants, url.StickyVariants = variants, sticky
+		renderRulesPage(c, http.StatusUnprocessableEntity, url, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	url.Variants, url.StickyVariants = saved, sticky
+	renderRulesPage(c, http.StatusOK, url, gin.H{"Message": "Variants saved."})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
 	"github.com/spf13/viper"
@@ -22,6 +23,28 @@ var (
 	userDAO   *UserDAO
 	statsDAO  *StatsDAO
 
+	webhookDAO   *WebhookDAO
+	blocklistDAO *BlocklistDAO
+
+	botDetector *BotDetector
+	geoIP       *GeoIPResolver
+	urlPolicy   *URLPolicy
+	blocklist   *Blocklist
+
+	metadataFetcher *MetadataFetcher
+
+	sessionStore sessions.Store
+
+	// queryPolicy is the QUERY_PASSTHROUGH policy of links without one of their own.
+	queryPolicy string
+
+	// notYetAvailableURL is where visitors of links not live yet are sent, NOT_YET_AVAILABLE_URL.
+	// Empty shows the notyet.html page.
+	notYetAvailableURL string
+
+	// littleuDomains are the hosts serving our own short links.
+	littleuDomains map[string]bool
+
 	serverPort string
 
 	ctx                context.Context
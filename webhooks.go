@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	eventLinkCreated = "link.created"
+	eventLinkChanged = "link.changed"
+	eventLinkDeleted = "link.deleted"
+	eventLinkClicked = "link.clicked"
+	eventTest        = "test"
+
+	// webhookMaxAttempts is the number of deliveries tried before an event is given up.
+	webhookMaxAttempts = 5
+	webhookTimeout     = 10 * time.Second
+	// webhookLogSize is the number of deliveries shown per webhook.
+	webhookLogSize = 20
+
+	webhookSignatureHeader = "X-Littleu-Signature"
+)
+
+// webhookEvents are the events a webhook can subscribe to.
+var webhookEvents = []string{eventLinkCreated, eventLinkChanged, eventLinkDeleted, eventLinkClicked}
+
+// webhookClient delivers the events, setup replaces it to allow WEBHOOK_ALLOWED_NETWORKS.
+var webhookClient = newWebhookClient(nil)
+
+// webhookFirstRetry is the wait before the first retry, it doubles on every attempt.
+var webhookFirstRetry = 2 * time.Second
+
+// WebhookEvent is the JSON body POSTed to subscribers.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// LinkEventData is the payload of the link.* events.
+type LinkEventData struct {
+	ShortURL string `json:"short_url"`
+	URL      string `json:"url,omitempty"`
+	OldShort string `json:"old_short_url,omitempty"`
+}
+
+func (hook *Webhook) subscribedTo(event string) bool {
+	if event == eventTest {
+		return true
+	}
+
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signWebhookPayload returns the hex encoded HMAC-SHA256 of the body with the webhook secret,
+// receivers recompute it to check the event came from us.
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookSecret() string {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	return hex.EncodeToString(secret)
+}
+
+func newEventID() string {
+	id, _ := uuid.NewV4()
+
+	return id.String()
+}
+
+// dispatchEvent sends the event to every webhook of the user subscribed to it. Deliveries run
+// in the background, callers are never held up by slow receivers.
+func dispatchEvent(userKey, event string, data interface{}) {
+	if userKey == "" {
+		return
+	}
+
+	hooks, err := (*webhookDAO).findAllByUser(userKey)
+	if err != nil {
+		log.Printf("error getting webhooks for %s: %v", event, err)
+
+		return
+	}
+
+	payload := WebhookEvent{
+		ID:        newEventID(),
+		Event:     event,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+
+	for _, hook := range hooks {
+		if hook.subscribedTo(event) {
+			hook := hook
+			runInBackground(func() { deliverWebhook(hook, payload) })
+		}
+	}
+}
+
+// dispatchLinkEvent dispatches an event about a link to the webhooks of its owner.
+func dispatchLinkEvent(shortID int, event string, data LinkEventData) {
+	owner, err := (*urlDAO).ownerOf(shortID)
+	if err != nil {
+		log.Printf("error getting owner of %d for %s: %v", shortID, event, err)
+
+		return
+	}
+
+	dispatchEvent(owner, event, data)
+}
+
+// deliverWebhook POSTs the event until the receiver answers with a 2xx or webhookMaxAttempts is
+// reached, waiting exponentially longer between attempts. Every attempt is logged.
+func deliverWebhook(hook Webhook, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("error encoding webhook event: %v", err)
+
+		return
+	}
+
+	wait := webhookFirstRetry
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if attemptWebhook(hook, event, body, attempt) {
+			return
+		}
+
+		if attempt == webhookMaxAttempts {
+			return
+		}
+
+		// A shutdown does not wait for the retries, the failed attempts are in the delivery log.
+		select {
+		case <-shuttingDown:
+			return
+		case <-time.After(wait):
+			wait *= 2
+		}
+	}
+}
+
+// attemptWebhook makes a single delivery, records it in the delivery log and reports whether
+// the receiver accepted it.
+func attemptWebhook(hook Webhook, event WebhookEvent, body []byte, attempt int) bool {
+	statusCode, err := postWebhook(hook, event, body)
+
+	delivery := WebhookDelivery{
+		ID:         newEventID(),
+		WebhookID:  hook.ID,
+		EventID:    event.ID,
+		Event:      event.Event,
+		Attempt:    attempt,
+		StatusCode: statusCode,
+		CreatedAt:  time.Now(),
+	}
+
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	if err := (*webhookDAO).saveDelivery(delivery); err != nil {
+		log.Printf("error saving webhook delivery: %v", err)
+	}
+
+	return err == nil && statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices
+}
+
+// newWebhookClient returns a client which does not connect to internal networks other than
+// allowed, whether the webhook URL, a redirect or a DNS answer points there.
+func newWebhookClient(allowed []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: checkAddress(allowed),
+	}
+
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   webhookTimeout,
+			ResponseHeaderTimeout: webhookTimeout,
+		},
+	}
+}
+
+func postWebhook(hook Webhook, event WebhookEvent, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("error creating webhook request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "littleu-webhooks")
+	request.Header.Set("X-Littleu-Event", event.Event)
+	request.Header.Set("X-Littleu-Delivery", event.ID)
+	request.Header.Set(webhookSignatureHeader, signWebhookPayload(hook.Secret, body))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("error delivering webhook: %w", err)
+	}
+
+	defer response.Body.Close()
+
+	return response.StatusCode, nil
+}
+
+func validateWebhook(rawURL string, events []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errorInvalidWebhook("the url must be an absolute http(s) url")
+	}
+
+	if len(events) == 0 {
+		return errorInvalidWebhook("pick at least one event")
+	}
+
+	for _, event := range events {
+		known := false
+
+		for _, e := range webhookEvents {
+			if e == event {
+				known = true
+			}
+		}
+
+		if !known {
+			return errorInvalidWebhook("unknown event " + event)
+		}
+	}
+
+	return nil
+}
+
+func renderWebhooksPage(c *gin.Context, status int, userKey string, data gin.H) {
+	hooks, err := (*webhookDAO).findAllByUser(userKey)
+	if err != nil {
+		data["ErrorMessage"] = err.Error()
+	}
+
+	type webhookView struct {
+		Webhook
+		Deliveries []WebhookDelivery
+	}
+
+	views := make([]webhookView, 0, len(hooks))
+
+	for _, hook := range hooks {
+		deliveries, err := (*webhookDAO).findDeliveries(hook.ID, webhookLogSize)
+		if err != nil {
+			log.Printf("error getting webhook deliveries: %v", err)
+		}
+
+		views = append(views, webhookView{Webhook: hook, Deliveries: deliveries})
+	}
+
+	data["title"] = "Webhooks"
+	data["webhooks"] = views
+	data["events"] = webhookEvents
+
+	c.HTML(status, "webhooks.html", data)
+}
+
+// sessionUserKey returns the key of the logged in user, rendering the error page when there is none.
+func sessionUserKey(c *gin.Context) (string, bool) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	key, ok := userKey(&userFound)
+	if !ok {
+		c.HTML(
+			http.StatusUnauthorized,
+			"error5xx.html",
+			gin.H{
+				"title":             "Error",
+				"error_description": `You have to be logged in.`,
+			},
+		)
+
+		return "", false
+	}
+
+	return key, true
+}
+
+func showWebhooksPage(c *gin.Context) {
+	key, ok := sessionUserKey(c)
+	if !ok {
+		return
+	}
+
+	renderWebhooksPage(c, http.StatusOK, key, gin.H{})
+}
+
+func createWebhook(c *gin.Context) {
+	key, ok := sessionUserKey(c)
+	if !ok {
+		return
+	}
+
+	hookURL := strings.TrimSpace(c.PostForm("url"))
+	events := c.PostFormArray("events")
+
+	if err := validateWebhook(hookURL, events); err != nil {
+		renderWebhooksPage(c, http.StatusBadRequest, key, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	hook := Webhook{
+		ID:        newEventID(),
+		UserKey:   key,
+		URL:       hookURL,
+		Secret:    newWebhookSecret(),
+		Events:    events,
+		CreatedAt: time.Now(),
+	}
+
+	if err := (*webhookDAO).save(hook); err != nil {
+		renderWebhooksPage(c, http.StatusInternalServerError, key, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	// The signing secret is only shown here, it is left out of the page and the API afterwards.
+	renderWebhooksPage(c, http.StatusCreated, key, gin.H{"created": hook})
+}
+
+func deleteWebhook(c *gin.Context) {
+	key, ok := sessionUserKey(c)
+	if !ok {
+		return
+	}
+
+	if err := (*webhookDAO).delete(key, c.Param("id")); err != nil {
+		renderWebhooksPage(c, http.StatusNotFound, key, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/webhooks")
+}
+
+// testWebhook sends a "test" event to a single webhook, whatever it is subscribed to.
+func testWebhook(c *gin.Context) {
+	key, ok := sessionUserKey(c)
+	if !ok {
+		return
+	}
+
+	hook, err := (*webhookDAO).findByID(key, c.Param("id"))
+	if err != nil {
+		renderWebhooksPage(c, http.StatusNotFound, key, gin.H{"ErrorMessage": err.Error()})
+
+		return
+	}
+
+	event := WebhookEvent{
+		ID:        newEventID(),
+		Event:     eventTest,
+		CreatedAt: time.Now(),
+		Data:      gin.H{"message": "this is a test event from littleu"},
+	}
+
+	// The test event is delivered once and right away, so its result is already in the
+	// delivery log when the page reloads.
+	body, _ := json.Marshal(event)
+	attemptWebhook(hook, event, body, 1)
+
+	c.Redirect(http.StatusSeeOther, "/webhooks")
+}
+
+func viewWebhookDeliveries(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	key, ok := userKey(&userFound)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	hook, err := (*webhookDAO).findByID(key, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+
+		return
+	}
+
+	deliveries, err := (*webhookDAO).findDeliveries(hook.ID, webhookLogSize)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func viewWebhooks(c *gin.Context) {
+	session := sessions.Default(c)
+	userFound := session.Get("user_logged_in")
+
+	key, ok := userKey(&userFound)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "unauthorized"})
+
+		return
+	}
+
+	hooks, err := (*webhookDAO).findAllByUser(key)
+	if err != nil {
+		_ = c.AbortWithError(http.StatusInternalServerError, err)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	got := signWebhookPayload("secret", []byte(`{"event":"test"}`))
+	want := "sha256=8419ab361b37d61b696d008ef7549a18325132dae5da84c7424e8e1c590d0498"
+
+	if got != want {
+		t.Fatalf("signWebhookPayload() = %q, want %q", got, want)
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	tests := []struct {
+		url    string
+		events []string
+		valid  bool
+	}{
+		{url: "https://example.com/hook", events: []string{eventLinkCreated}, valid: true},
+		{url: "http://example.com/hook", events: webhookEvents, valid: true},
+		{url: "ftp://example.com/hook", events: []string{eventLinkCreated}},
+		{url: "/hook", events: []string{eventLinkCreated}},
+		{url: "https://example.com/hook"},
+		{url: "https://example.com/hook", events: []string{"link.exploded"}},
+		{url: "https://example.com/hook", events: []string{eventTest}},
+	}
+
+	for _, tt := range tests {
+		err := validateWebhook(tt.url, tt.events)
+
+		if tt.valid && err != nil {
+			t.Errorf("validateWebhook(%q, %q) = %v, want no error", tt.url, tt.events, err)
+		}
+
+		if !tt.valid && !errors.Is(err, errInvalidWebhook) {
+			t.Errorf("validateWebhook(%q, %q) = %v, want an invalid webhook error", tt.url, tt.events, err)
+		}
+	}
+}
+
+// webhookReceiver is a test server answering the webhook deliveries with the given status codes
+// in turn, it checks every one of them is signed with secret.
+type webhookReceiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+
+	mu     sync.Mutex
+	events []WebhookEvent
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Error(err)
+	}
+
+	if got, want := req.Header.Get(webhookSignatureHeader), signWebhookPayload(r.secret, body); got != want {
+		r.t.Errorf("signature = %q, want %q", got, want)
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		r.t.Error(err)
+	}
+
+	if got := req.Header.Get("X-Littleu-Event"); got != event.Event {
+		r.t.Errorf("X-Littleu-Event = %q, want %q", got, event.Event)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := http.StatusOK
+	if len(r.events) < len(r.statuses) {
+		status = r.statuses[len(r.events)]
+	}
+
+	r.events = append(r.events, event)
+
+	w.WriteHeader(status)
+}
+
+func (r *webhookReceiver) received() []WebhookEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]WebhookEvent{}, r.events...)
+}
+
+func useFastWebhookRetries(t *testing.T) {
+	t.Helper()
+
+	firstRetry := webhookFirstRetry
+	webhookFirstRetry = time.Millisecond
+
+	t.Cleanup(func() { webhookFirstRetry = firstRetry })
+}
+
+// allowLocalWebhooks lets webhooks be delivered to the httptest servers on the loopback address.
+func allowLocalWebhooks(t *testing.T) {
+	t.Helper()
+
+	client := webhookClient
+	webhookClient = newWebhookClient(parseNetworks([]string{"127.0.0.1", "::1"}))
+
+	t.Cleanup(func() { webhookClient = client })
+}
+
+func TestDeliverWebhookRetries(t *testing.T) {
+	useMemoryEngine(t)
+	useFastWebhookRetries(t)
+	allowLocalWebhooks(t)
+
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{name: "accepted", statuses: []int{http.StatusNoContent}, attempts: 1},
+		{name: "accepted after errors", statuses: []int{http.StatusInternalServerError, http.StatusFound}, attempts: 3},
+		{name: "never accepted", statuses: []int{500, 502, 503, 504, 500, 500}, attempts: webhookMaxAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &webhookReceiver{t: t, secret: newWebhookSecret(), statuses: tt.statuses}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			hook := Webhook{ID: newEventID(), UserKey: "owner", URL: server.URL, Secret: receiver.secret, Events: webhookEvents}
+			if err := (*webhookDAO).save(hook); err != nil {
+				t.Fatal(err)
+			}
+
+			event := WebhookEvent{ID: newEventID(), Event: eventLinkCreated, Data: LinkEventData{ShortURL: "b"}}
+
+			deliverWebhook(hook, event)
+
+			received := receiver.received()
+			if len(received) != tt.attempts {
+				t.Fatalf("%d deliveries, want %d", len(received), tt.attempts)
+			}
+
+			for _, e := range received {
+				if e.ID != event.ID {
+					t.Fatalf("delivered event %q, want %q", e.ID, event.ID)
+				}
+			}
+
+			deliveries, err := (*webhookDAO).findDeliveries(hook.ID, webhookLogSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(deliveries) != tt.attempts {
+				t.Fatalf("%d deliveries logged, want %d", len(deliveries), tt.attempts)
+			}
+
+			for i, delivery := range deliveries {
+				if want := tt.attempts - i; delivery.Attempt != want || delivery.EventID != event.ID {
+					t.Errorf("delivery %d = attempt %d of %q, want attempt %d of %q",
+						i, delivery.Attempt, delivery.EventID, want, event.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverWebhookUnreachable(t *testing.T) {
+	useMemoryEngine(t)
+	useFastWebhookRetries(t)
+	allowLocalWebhooks(t)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	hook := Webhook{ID: newEventID(), UserKey: "owner", URL: server.URL, Secret: "secret", Events: webhookEvents}
+	if err := (*webhookDAO).save(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverWebhook(hook, WebhookEvent{ID: newEventID(), Event: eventTest})
+
+	deliveries, err := (*webhookDAO).findDeliveries(hook.ID, webhookLogSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != webhookMaxAttempts {
+		t.Fatalf("%d deliveries logged, want %d", len(deliveries), webhookMaxAttempts)
+	}
+
+	if deliveries[0].Error == "" || deliveries[0].StatusCode != 0 {
+		t.Fatalf("delivery = %+v, want an error without a status code", deliveries[0])
+	}
+}
+
+func TestDeliverWebhookShutdown(t *testing.T) {
+	useMemoryEngine(t)
+	allowLocalWebhooks(t)
+
+	stopping := shuttingDown
+	shuttingDown = make(chan struct{})
+	close(shuttingDown)
+
+	t.Cleanup(func() { shuttingDown = stopping })
+
+	receiver := &webhookReceiver{t: t, secret: "secret", statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook := Webhook{ID: newEventID(), UserKey: "owner", URL: server.URL, Secret: "secret", Events: webhookEvents}
+	if err := (*webhookDAO).save(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		deliverWebhook(hook, WebhookEvent{ID: newEventID(), Event: eventTest})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the retries did not stop on shutdown")
+	}
+
+	if received := receiver.received(); len(received) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(received))
+	}
+}
+
+func TestDeliverWebhookInternalAddress(t *testing.T) {
+	useMemoryEngine(t)
+	useFastWebhookRetries(t)
+
+	receiver := &webhookReceiver{t: t, secret: "secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook := Webhook{ID: newEventID(), UserKey: "owner", URL: server.URL, Secret: "secret", Events: webhookEvents}
+	if err := (*webhookDAO).save(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverWebhook(hook, WebhookEvent{ID: newEventID(), Event: eventTest})
+
+	if received := receiver.received(); len(received) != 0 {
+		t.Fatalf("%d events delivered to an internal address", len(received))
+	}
+
+	deliveries, err := (*webhookDAO).findDeliveries(hook.ID, webhookLogSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) == 0 || !strings.Contains(deliveries[0].Error, "internal address") {
+		t.Fatalf("deliveries = %+v, want the internal address refused", deliveries)
+	}
+}
+
+func TestDispatchEventSubscriptions(t *testing.T) {
+	useMemoryEngine(t)
+	allowLocalWebhooks(t)
+
+	receiver := &webhookReceiver{t: t, secret: "secret"}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	for _, hook := range []Webhook{
+		{ID: newEventID(), UserKey: "owner", URL: server.URL + "/created", Secret: "secret", Events: []string{eventLinkCreated}},
+		{ID: newEventID(), UserKey: "owner", URL: server.URL + "/deleted", Secret: "secret", Events: []string{eventLinkDeleted}},
+		{ID: newEventID(), UserKey: "someone else", URL: server.URL + "/other", Secret: "secret", Events: webhookEvents},
+	} {
+		if err := (*webhookDAO).save(hook); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dispatchEvent("owner", eventLinkCreated, LinkEventData{ShortURL: "b"})
+	dispatchEvent("", eventLinkCreated, LinkEventData{ShortURL: "c"})
+	backgroundTasks.Wait()
+
+	received := receiver.received()
+	if len(received) != 1 || received[0].Event != eventLinkCreated {
+		t.Fatalf("received %+v, want a single %s event", received, eventLinkCreated)
+	}
+}
+
+func TestWebhookSecretShownOnce(t *testing.T) {
+	config := useMemoryEngine(t)
+	config.Set("SESSION_SECRET", "secret")
+	config.Set("SESSION_STORE", sessionStoreMemory)
+
+	store, err := newSessionStore(config, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := addTestUser(t, "hooks")
+
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.LoadHTMLGlob("templates/*")
+	router.Use(sessions.Sessions("sid", store), func(c *gin.Context) {
+		sessions.Default(c).Set("user_logged_in", user)
+	})
+	router.GET("/webhooks", showWebhooksPage)
+	router.POST("/webhooks", createWebhook)
+	router.GET("/api/webhooks", viewWebhooks)
+
+	form := url.Values{"url": {"https://example.com/hook"}, "events": {eventLinkCreated}}
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	key, _ := userKey(&user)
+
+	hooks, err := (*webhookDAO).findAllByUser(key)
+	if err != nil || len(hooks) != 1 {
+		t.Fatalf("findAllByUser() = %+v, %v, want the new webhook", hooks, err)
+	}
+
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), hooks[0].Secret) {
+		t.Fatalf("status = %d, want %d and the signing secret shown", w.Code, http.StatusCreated)
+	}
+
+	for _, path := range []string{"/webhooks", "/api/webhooks"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), hooks[0].Secret) {
+			t.Errorf("GET %s = %d, the signing secret is shown again", path, w.Code)
+		}
+	}
+}